@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
@@ -18,16 +19,28 @@ import (
 )
 
 const (
-	msgTypeAlert    string = "alert"
-	msgTypeBits     string = "bits"
-	msgTypeCustom   string = "custom"
-	msgTypeDonation string = "donation"
-	msgTypeFollow   string = "follow"
-	msgTypeHost     string = "host"
-	msgTypeRaid     string = "raid"
-	msgTypeStore    string = "store"
-	msgTypeSub      string = "sub"
-	msgTypeSubGift  string = "subgift"
+	msgTypeAlert              string = "alert"
+	msgTypeBits               string = "bits"
+	msgTypeChannelUpdate      string = "channel_update"
+	msgTypeCustom             string = "custom"
+	msgTypeDonation           string = "donation"
+	msgTypeFollow             string = "follow"
+	msgTypeHost               string = "host"
+	msgTypeHypeTrainBegin     string = "hypetrain_begin"
+	msgTypeHypeTrainEnd       string = "hypetrain_end"
+	msgTypeHypeTrainProgress  string = "hypetrain_progress"
+	msgTypePollBegin          string = "poll_begin"
+	msgTypePollEnd            string = "poll_end"
+	msgTypePollProgress       string = "poll_progress"
+	msgTypePredictionBegin    string = "prediction_begin"
+	msgTypePredictionEnd      string = "prediction_end"
+	msgTypePredictionLock     string = "prediction_lock"
+	msgTypePredictionProgress string = "prediction_progress"
+	msgTypeRaid               string = "raid"
+	msgTypeRedemption         string = "redemption"
+	msgTypeStore              string = "store"
+	msgTypeSub                string = "sub"
+	msgTypeSubGift            string = "subgift"
 
 	msgTypeReplay string = "replay"
 )
@@ -133,14 +146,22 @@ var upgrader = websocket.Upgrader{
 }
 
 func registerAPI(r *mux.Router) {
+	r.HandleFunc("/auth/callback", handleAuthCallback).Methods(http.MethodGet)
+	r.HandleFunc("/auth/login", handleAuthLogin).Methods(http.MethodGet)
+
 	r.HandleFunc("/api/custom-alert", handleCustomAlert).Methods(http.MethodPost)
 	r.HandleFunc("/api/custom-event", handleCustomEvent).Methods(http.MethodPost)
 	r.HandleFunc("/api/demo/{event}", handleDemoAlert).Methods(http.MethodPut)
+	r.HandleFunc("/api/goals/{type:(?:bits|donations)}", handleSetLocalGoal).Methods(http.MethodPut)
+	r.HandleFunc("/api/goals/{type:(?:bits|donations)}", handleDeleteLocalGoal).Methods(http.MethodDelete)
 	r.HandleFunc("/api/follows/clear-last", handleSetLastFollower).Methods(http.MethodPut)
 	r.HandleFunc("/api/follows/set-last/{name}", handleSetLastFollower).Methods(http.MethodPut)
+	r.HandleFunc("/api/redemptions/{id}/{status:(?:fulfilled|canceled)}", handleUpdateRedemption).Methods(http.MethodPut)
 	r.HandleFunc("/api/subscribe", handleUpdateSocket).Methods(http.MethodGet)
+	r.HandleFunc("/api/token/status", handleTokenStatus).Methods(http.MethodGet)
 	r.HandleFunc("/api/webhook/{type}", handleWebHookPush)
 	r.HandleFunc("/api/eventsub", handleEventsubPush)
+	r.HandleFunc("/api/eventsub/status", handleEventSubStatus).Methods(http.MethodGet)
 }
 
 func handleCustomAlert(w http.ResponseWriter, r *http.Request) {
@@ -207,6 +228,56 @@ func handleSetLastFollower(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusAccepted)
 }
 
+func handleUpdateRedemption(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars     = mux.Vars(r)
+		id       = vars["id"]
+		status   = strings.ToUpper(vars["status"])
+		rewardID string
+	)
+
+	store.WithModRLock(func() error {
+		for _, rd := range store.Redemptions.Recent {
+			if rd.ID == id {
+				rewardID = rd.RewardID
+			}
+		}
+		return nil
+	})
+
+	if rewardID == "" {
+		http.Error(w, "Redemption not found", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), twitchRequestTimeout)
+	defer cancel()
+
+	if err := updateTwitchRedemptionStatus(ctx, rewardID, id, status); err != nil {
+		http.Error(w, errors.Wrap(err, "update redemption status").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	store.WithModLock(func() error {
+		for i := range store.Redemptions.Recent {
+			if store.Redemptions.Recent[i].ID == id {
+				store.Redemptions.Recent[i].Status = status
+			}
+		}
+		return nil
+	})
+
+	if err := store.Save(cfg.StoreFile); err != nil {
+		log.WithError(err).Error("Unable to update persistent store")
+	}
+
+	if err := store.WithModRLock(func() error { return subscriptions.SendAllSockets(msgTypeStore, store, false, false) }); err != nil {
+		log.WithError(err).Error("Unable to send update to all sockets")
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
 func handleUpdateSocket(w http.ResponseWriter, r *http.Request) {
 	// Upgrade connection to socket
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -275,7 +346,7 @@ func handleUpdateSocket(w http.ResponseWriter, r *http.Request) {
 			return
 
 		default:
-			log.Debug("Got unhandled message from socket: %d", messageType)
+			log.Debugf("Got unhandled message from socket: %d", messageType)
 			continue
 		}
 
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	authStateTimeout  = 10 * time.Minute
+	userTokenFileName = "user-token.enc"
+)
+
+var authStates = struct {
+	states map[string]time.Time
+	lock   sync.Mutex
+}{states: make(map[string]time.Time)}
+
+func handleAuthLogin(w http.ResponseWriter, r *http.Request) {
+	state := uuid.Must(uuid.NewV4()).String()
+
+	authStates.lock.Lock()
+	for s, created := range authStates.states {
+		if time.Since(created) > authStateTimeout {
+			delete(authStates.states, s)
+		}
+	}
+	authStates.states[state] = time.Now()
+	authStates.lock.Unlock()
+
+	params := make(url.Values)
+	params.Set("client_id", cfg.TwitchClient)
+	params.Set("redirect_uri", authRedirectURL())
+	params.Set("response_type", "code")
+	params.Set("scope", strings.Join(twitchRequiredScopes(), " "))
+	params.Set("state", state)
+
+	http.Redirect(w, r, helix.IDURL("authorize", params), http.StatusFound)
+}
+
+func handleAuthCallback(w http.ResponseWriter, r *http.Request) {
+	state := r.FormValue("state")
+
+	authStates.lock.Lock()
+	created, ok := authStates.states[state]
+	delete(authStates.states, state)
+	authStates.lock.Unlock()
+
+	if !ok || time.Since(created) > authStateTimeout {
+		http.Error(w, "invalid or expired state", http.StatusBadRequest)
+		return
+	}
+
+	if errMsg := r.FormValue("error"); errMsg != "" {
+		http.Error(w, "authorization failed: "+r.FormValue("error_description"), http.StatusBadRequest)
+		return
+	}
+
+	params := make(url.Values)
+	params.Set("client_id", cfg.TwitchClient)
+	params.Set("client_secret", cfg.TwitchSecret)
+	params.Set("code", r.FormValue("code"))
+	params.Set("grant_type", "authorization_code")
+	params.Set("redirect_uri", authRedirectURL())
+
+	var token oauthTokenResponse
+	if err := helix.DoID(r.Context(), http.MethodPost, "token", params, "", &token); err != nil {
+		log.WithError(err).Error("Unable to exchange authorization code")
+		http.Error(w, "unable to exchange authorization code", http.StatusInternalServerError)
+		return
+	}
+
+	validation, err := validateToken(r.Context(), token.AccessToken)
+	if err != nil {
+		log.WithError(err).Error("Unable to validate obtained token")
+		http.Error(w, "unable to validate token", http.StatusInternalServerError)
+		return
+	}
+
+	if validation.UserID != cfg.TwitchID {
+		http.Error(w, "token does not belong to the configured user", http.StatusForbidden)
+		return
+	}
+
+	if err := userAccessTokens.Set(token); err != nil {
+		log.WithError(err).Error("Unable to store user-access-token")
+		http.Error(w, "unable to store token", http.StatusInternalServerError)
+		return
+	}
+
+	log.WithField("login", validation.Login).Info("Obtained new user-access-token")
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("Authorization successful, you can close this window.\n"))
+}
+
+// authRedirectURL returns the callback URL registered with Twitch
+func authRedirectURL() string {
+	if cfg.BaseURL != "" {
+		return strings.Join([]string{strings.TrimRight(cfg.BaseURL, "/"), "auth", "callback"}, "/")
+	}
+
+	_, port, err := net.SplitHostPort(cfg.Listen)
+	if err != nil {
+		port = "3000"
+	}
+
+	return "http://localhost:" + port + "/auth/callback"
+}
+
+func userTokenFile() string {
+	return filepath.Join(filepath.Dir(cfg.StoreFile), userTokenFileName)
+}
+
+// readEncryptedFile decrypts the file using a key derived from the
+// client secret and decodes the contained JSON into out
+func readEncryptedFile(filename string, out interface{}) error {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := fileCipher()
+	if err != nil {
+		return err
+	}
+
+	if len(raw) < gcm.NonceSize() {
+		return errors.New("file too short")
+	}
+
+	plain, err := gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], nil)
+	if err != nil {
+		return errors.Wrap(err, "decrypting file")
+	}
+
+	return errors.Wrap(json.NewDecoder(bytes.NewReader(plain)).Decode(out), "decoding file")
+}
+
+// writeEncryptedFile encodes the data as JSON and stores it encrypted
+// using a key derived from the client secret
+func writeEncryptedFile(filename string, data interface{}) error {
+	plain, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "encoding data")
+	}
+
+	gcm, err := fileCipher()
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return errors.Wrap(err, "generating nonce")
+	}
+
+	return errors.Wrap(
+		ioutil.WriteFile(filename, gcm.Seal(nonce, nonce, plain, nil), 0o600),
+		"writing file",
+	)
+}
+
+func fileCipher() (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(cfg.TwitchSecret))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "creating cipher")
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	return gcm, errors.Wrap(err, "creating GCM")
+}
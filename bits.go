@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	bitDonationDedupWindow = 2 * time.Minute
+	bitDonationAnonLogin   = "ananonymouscheerer"
+
+	bitDonationSourceEventSub = "eventsub"
+	bitDonationSourceIRC      = "irc"
+)
+
+var bitDonations = newBitDonationHandler()
+
+type (
+	bitDonationHandler struct {
+		seen map[string][]bitDonationMark
+		lock sync.Mutex
+	}
+
+	bitDonationMark struct {
+		Source string
+		Time   time.Time
+	}
+)
+
+func newBitDonationHandler() *bitDonationHandler {
+	return &bitDonationHandler{
+		seen: make(map[string][]bitDonationMark),
+	}
+}
+
+// Process announces the bit donation and updates the store unless the
+// same cheer was already reported through the other transport (IRC
+// or EventSub) within the dedup window. Returns whether the donation
+// was processed.
+func (b *bitDonationHandler) Process(source, userID, login, displayName string, amount int64, message string) bool {
+	if strings.ToLower(login) == bitDonationAnonLogin {
+		userID, login = "", ""
+	}
+
+	if !b.markSeen(source, strings.Join([]string{userID, strconv.FormatInt(amount, 10)}, ":")) {
+		log.WithFields(log.Fields{
+			"amount": amount,
+			"from":   displayName,
+		}).Debug("Bit donation already announced, skipping")
+		return false
+	}
+
+	fields := map[string]interface{}{
+		"from":    displayName,
+		"amount":  amount,
+		"is_anon": login == "",
+		"message": message,
+	}
+
+	if login != "" {
+		fields["from_id"] = userID
+		fields["from_login"] = login
+	}
+
+	userProfiles.Enrich(fields, userID, login)
+
+	store.WithModLock(func() error {
+		store.BitDonations.LastDonator = &displayName
+		store.BitDonations.LastAmount = amount
+		if store.Session.Live {
+			store.Session.Bits += amount
+		}
+		addLocalGoalProgress(goalTypeBits, float64(amount))
+
+		if login == "" {
+			// Anonymous cheers are not attributed to anyone
+			return nil
+		}
+
+		if store.BitDonations.TotalAmounts == nil {
+			store.BitDonations.TotalAmounts = map[string]int64{}
+		}
+		store.BitDonations.TotalAmounts[login] += amount
+
+		fields["total_amount"] = store.BitDonations.TotalAmounts[login]
+
+		return nil
+	})
+
+	log.WithFields(log.Fields(fields)).Info("Bit donation")
+	if err := subscriptions.SendAllSockets(msgTypeBits, fields, false, true); err != nil {
+		log.WithError(err).Error("Unable to send update to all sockets")
+	}
+
+	return true
+}
+
+// markSeen consumes a mark of the same cheer left by the other source
+// and returns false if there was one. Otherwise the cheer is marked
+// for the other source and true is returned.
+func (b *bitDonationHandler) markSeen(source, key string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for k, marks := range b.seen {
+		var keep []bitDonationMark
+		for _, m := range marks {
+			if time.Since(m.Time) <= bitDonationDedupWindow {
+				keep = append(keep, m)
+			}
+		}
+
+		if len(keep) == 0 {
+			delete(b.seen, k)
+			continue
+		}
+		b.seen[k] = keep
+	}
+
+	for i, m := range b.seen[key] {
+		if m.Source != source {
+			b.seen[key] = append(b.seen[key][:i], b.seen[key][i+1:]...)
+			return false
+		}
+	}
+
+	b.seen[key] = append(b.seen[key], bitDonationMark{Source: source, Time: time.Now()})
+	return true
+}
@@ -52,6 +52,17 @@ func handleDemoAlert(w http.ResponseWriter, r *http.Request) {
 			"viewerCount": demoGetParamInt(r, "viewerCount", 5),
 		}
 
+	case msgTypeRedemption:
+		data = map[string]interface{}{
+			"id":           "demo",
+			"from":         demoIssuer,
+			"reward_id":    "demo",
+			"reward_title": demoGetParamStr(r, "reward_title", "Hydrate!"),
+			"cost":         demoGetParamInt(r, "cost", 500),
+			"input":        demoGetParamStr(r, "input", ""),
+			"status":       "UNFULFILLED",
+		}
+
 	case msgTypeSub:
 		data = map[string]interface{}{
 			"from":     demoIssuer,
@@ -76,14 +87,15 @@ func handleDemoAlert(w http.ResponseWriter, r *http.Request) {
 		}
 
 	case msgTypeSubGift:
+		// EventSub announces the gifter with the number of gifted subs,
+		// the recipients are not known at that point
 		data = map[string]interface{}{
-			"from":     demoIssuer,
-			"is_anon":  demoGetParamStr(r, "is_anon", "false") == "true",
-			"gift_to":  demoGetParamStr(r, "gift_to", "Tester"),
-			"paid_for": demoGetParamInt(r, "paid_for", 1),
-			"streak":   demoGetParamInt(r, "streak", 12),
-			"tier":     demoGetParamStr(r, "tier", "1000"),
-			"total":    demoGetParamInt(r, "total", 12),
+			"from":             demoIssuer,
+			"is_anon":          demoGetParamStr(r, "is_anon", "false") == "true",
+			"amount":           demoGetParamInt(r, "amount", 5),
+			"cumulative_total": demoGetParamInt(r, "cumulative_total", 12),
+			"paid_for":         1,
+			"tier":             demoGetParamStr(r, "tier", "1000"),
 		}
 
 	default:
@@ -8,8 +8,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
@@ -31,17 +31,61 @@ const (
 	eventSubMessageTypeVerification = "webhook_callback_verification"
 	eventSubMessageTypeRevokation   = "revocation"
 
+	eventSubMaxMessageAge = 10 * time.Minute
+
 	eventSubStatusAuthorizationRevoked = "authorization_revoked"
 	eventSubStatusEnabled              = "enabled"
 	eventSubStatusFailuresExceeded     = "notification_failures_exceeded"
 	eventSubStatusUserRemoved          = "user_removed"
 	eventSubStatusVerificationFailed   = "webhook_callback_verification_failed"
 	eventSubStatusVerificationPending  = "webhook_callback_verification_pending"
+
+	// eventSubStatusFailed is no Twitch status but marks subscriptions
+	// we were unable to create (i.e. because of missing scopes)
+	eventSubStatusFailed = "failed"
 )
 
 type (
 	eventSubCondition struct {
-		BroadcasterUserID string `json:"broadcaster_user_id,omitempty"`
+		BroadcasterUserID   string `json:"broadcaster_user_id,omitempty"`
+		ModeratorUserID     string `json:"moderator_user_id,omitempty"`
+		ToBroadcasterUserID string `json:"to_broadcaster_user_id,omitempty"`
+	}
+	eventSubEventBan struct {
+		UserID               string     `json:"user_id"`
+		UserLogin            string     `json:"user_login"`
+		UserName             string     `json:"user_name"`
+		BroadcasterUserID    string     `json:"broadcaster_user_id"`
+		BroadcasterUserLogin string     `json:"broadcaster_user_login"`
+		BroadcasterUserName  string     `json:"broadcaster_user_name"`
+		ModeratorUserID      string     `json:"moderator_user_id"`
+		ModeratorUserLogin   string     `json:"moderator_user_login"`
+		ModeratorUserName    string     `json:"moderator_user_name"`
+		Reason               string     `json:"reason"`
+		BannedAt             time.Time  `json:"banned_at"`
+		EndsAt               *time.Time `json:"ends_at"`
+		IsPermanent          bool       `json:"is_permanent"`
+	}
+	eventSubEventChannelUpdate struct {
+		BroadcasterUserID    string `json:"broadcaster_user_id"`
+		BroadcasterUserLogin string `json:"broadcaster_user_login"`
+		BroadcasterUserName  string `json:"broadcaster_user_name"`
+		Title                string `json:"title"`
+		Language             string `json:"language"`
+		CategoryID           string `json:"category_id"`
+		CategoryName         string `json:"category_name"`
+		IsMature             bool   `json:"is_mature"`
+	}
+	eventSubEventCheer struct {
+		IsAnonymous          bool   `json:"is_anonymous"`
+		UserID               string `json:"user_id"`
+		UserLogin            string `json:"user_login"`
+		UserName             string `json:"user_name"`
+		BroadcasterUserID    string `json:"broadcaster_user_id"`
+		BroadcasterUserLogin string `json:"broadcaster_user_login"`
+		BroadcasterUserName  string `json:"broadcaster_user_name"`
+		Message              string `json:"message"`
+		Bits                 int64  `json:"bits"`
 	}
 	eventSubEventFollow struct {
 		UserID               string    `json:"user_id"`
@@ -52,6 +96,144 @@ type (
 		BroadcasterUserName  string    `json:"broadcaster_user_name"`
 		FollowedAt           time.Time `json:"followed_at"`
 	}
+	eventSubEventRaid struct {
+		FromBroadcasterUserID    string `json:"from_broadcaster_user_id"`
+		FromBroadcasterUserLogin string `json:"from_broadcaster_user_login"`
+		FromBroadcasterUserName  string `json:"from_broadcaster_user_name"`
+		ToBroadcasterUserID      string `json:"to_broadcaster_user_id"`
+		ToBroadcasterUserLogin   string `json:"to_broadcaster_user_login"`
+		ToBroadcasterUserName    string `json:"to_broadcaster_user_name"`
+		Viewers                  int64  `json:"viewers"`
+	}
+	eventSubEventRedemption struct {
+		ID                   string `json:"id"`
+		BroadcasterUserID    string `json:"broadcaster_user_id"`
+		BroadcasterUserLogin string `json:"broadcaster_user_login"`
+		BroadcasterUserName  string `json:"broadcaster_user_name"`
+		UserID               string `json:"user_id"`
+		UserLogin            string `json:"user_login"`
+		UserName             string `json:"user_name"`
+		UserInput            string `json:"user_input"`
+		Status               string `json:"status"`
+		Reward               struct {
+			ID     string `json:"id"`
+			Title  string `json:"title"`
+			Cost   int64  `json:"cost"`
+			Prompt string `json:"prompt"`
+		} `json:"reward"`
+		RedeemedAt time.Time `json:"redeemed_at"`
+	}
+	eventSubEventStreamOffline struct {
+		BroadcasterUserID    string `json:"broadcaster_user_id"`
+		BroadcasterUserLogin string `json:"broadcaster_user_login"`
+		BroadcasterUserName  string `json:"broadcaster_user_name"`
+	}
+	eventSubEventStreamOnline struct {
+		ID                   string    `json:"id"`
+		BroadcasterUserID    string    `json:"broadcaster_user_id"`
+		BroadcasterUserLogin string    `json:"broadcaster_user_login"`
+		BroadcasterUserName  string    `json:"broadcaster_user_name"`
+		Type                 string    `json:"type"`
+		StartedAt            time.Time `json:"started_at"`
+	}
+	eventSubEventSubscribe struct {
+		UserID               string `json:"user_id"`
+		UserLogin            string `json:"user_login"`
+		UserName             string `json:"user_name"`
+		BroadcasterUserID    string `json:"broadcaster_user_id"`
+		BroadcasterUserLogin string `json:"broadcaster_user_login"`
+		BroadcasterUserName  string `json:"broadcaster_user_name"`
+		Tier                 string `json:"tier"`
+		IsGift               bool   `json:"is_gift"`
+	}
+	eventSubEventSubscriptionGift struct {
+		UserID               string `json:"user_id"`
+		UserLogin            string `json:"user_login"`
+		UserName             string `json:"user_name"`
+		BroadcasterUserID    string `json:"broadcaster_user_id"`
+		BroadcasterUserLogin string `json:"broadcaster_user_login"`
+		BroadcasterUserName  string `json:"broadcaster_user_name"`
+		Total                int64  `json:"total"`
+		Tier                 string `json:"tier"`
+		CumulativeTotal      *int64 `json:"cumulative_total"`
+		IsAnonymous          bool   `json:"is_anonymous"`
+	}
+	eventSubEventSubscriptionMessage struct {
+		UserID               string `json:"user_id"`
+		UserLogin            string `json:"user_login"`
+		UserName             string `json:"user_name"`
+		BroadcasterUserID    string `json:"broadcaster_user_id"`
+		BroadcasterUserLogin string `json:"broadcaster_user_login"`
+		BroadcasterUserName  string `json:"broadcaster_user_name"`
+		Tier                 string `json:"tier"`
+		Message              struct {
+			Text string `json:"text"`
+		} `json:"message"`
+		CumulativeMonths int64  `json:"cumulative_months"`
+		StreakMonths     *int64 `json:"streak_months"`
+		DurationMonths   int64  `json:"duration_months"`
+	}
+	eventSubEventGoal struct {
+		ID                   string     `json:"id"`
+		BroadcasterUserID    string     `json:"broadcaster_user_id"`
+		BroadcasterUserLogin string     `json:"broadcaster_user_login"`
+		BroadcasterUserName  string     `json:"broadcaster_user_name"`
+		Type                 string     `json:"type"`
+		Description          string     `json:"description"`
+		IsAchieved           bool       `json:"is_achieved"`
+		CurrentAmount        int64      `json:"current_amount"`
+		TargetAmount         int64      `json:"target_amount"`
+		StartedAt            *time.Time `json:"started_at"`
+		EndedAt              *time.Time `json:"ended_at"`
+	}
+	eventSubEventHypeTrain struct {
+		ID                   string                          `json:"id"`
+		BroadcasterUserID    string                          `json:"broadcaster_user_id"`
+		BroadcasterUserLogin string                          `json:"broadcaster_user_login"`
+		BroadcasterUserName  string                          `json:"broadcaster_user_name"`
+		Level                int64                           `json:"level"`
+		Total                int64                           `json:"total"`
+		Progress             int64                           `json:"progress"`
+		Goal                 int64                           `json:"goal"`
+		TopContributions     []eventSubHypeTrainContribution `json:"top_contributions"`
+		StartedAt            *time.Time                      `json:"started_at"`
+		ExpiresAt            *time.Time                      `json:"expires_at"`
+		EndedAt              *time.Time                      `json:"ended_at"`
+		CooldownEndsAt       *time.Time                      `json:"cooldown_ends_at"`
+	}
+	eventSubHypeTrainContribution struct {
+		UserID    string `json:"user_id"`
+		UserLogin string `json:"user_login"`
+		UserName  string `json:"user_name"`
+		Type      string `json:"type"`
+		Total     int64  `json:"total"`
+	}
+	eventSubEventPoll struct {
+		ID                   string       `json:"id"`
+		BroadcasterUserID    string       `json:"broadcaster_user_id"`
+		BroadcasterUserLogin string       `json:"broadcaster_user_login"`
+		BroadcasterUserName  string       `json:"broadcaster_user_name"`
+		Title                string       `json:"title"`
+		Choices              []pollChoice `json:"choices"`
+		Status               string       `json:"status"`
+		StartedAt            *time.Time   `json:"started_at"`
+		EndsAt               *time.Time   `json:"ends_at"`
+		EndedAt              *time.Time   `json:"ended_at"`
+	}
+	eventSubEventPrediction struct {
+		ID                   string              `json:"id"`
+		BroadcasterUserID    string              `json:"broadcaster_user_id"`
+		BroadcasterUserLogin string              `json:"broadcaster_user_login"`
+		BroadcasterUserName  string              `json:"broadcaster_user_name"`
+		Title                string              `json:"title"`
+		Outcomes             []predictionOutcome `json:"outcomes"`
+		WinningOutcomeID     string              `json:"winning_outcome_id"`
+		Status               string              `json:"status"`
+		StartedAt            *time.Time          `json:"started_at"`
+		LocksAt              *time.Time          `json:"locks_at"`
+		LockedAt             *time.Time          `json:"locked_at"`
+		EndedAt              *time.Time          `json:"ended_at"`
+	}
 	eventSubPostMessage struct {
 		Challenge    string               `json:"challenge"`
 		Subscription eventSubSubscription `json:"subscription"`
@@ -68,9 +250,10 @@ type (
 		CreatedAt time.Time         `json:"created_at,omitempty"` // READONLY
 	}
 	eventSubTransport struct {
-		Method   string `json:"method"`
-		Callback string `json:"callback"`
-		Secret   string `json:"secret"`
+		Method    string `json:"method"`
+		Callback  string `json:"callback,omitempty"`
+		Secret    string `json:"secret,omitempty"`
+		SessionID string `json:"session_id,omitempty"`
 	}
 )
 
@@ -103,11 +286,30 @@ func handleEventsubPush(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	logger := log.WithField("type", message.Subscription.Type)
+	logger := log.WithFields(log.Fields{
+		"id":    r.Header.Get(eventSubHeaderMessageID),
+		"retry": r.Header.Get(eventSubHeaderMessageRetry),
+		"type":  message.Subscription.Type,
+	})
+
+	// Reject old messages to prevent replay attacks
+	msgTime, err := time.Parse(time.RFC3339Nano, r.Header.Get(eventSubHeaderMessageTimestamp))
+	if err != nil {
+		logger.WithError(err).Error("Unable to parse eventsub message timestamp")
+		http.Error(w, errors.Wrap(err, "parsing message timestamp").Error(), http.StatusBadRequest)
+		return
+	}
+
+	if time.Since(msgTime) > eventSubMaxMessageAge {
+		logger.WithField("timestamp", msgTime).Warn("Rejecting outdated eventsub message")
+		http.Error(w, "Message too old", http.StatusBadRequest)
+		return
+	}
 
 	// If we got a verification request, respond with the challenge
 	switch r.Header.Get(eventSubHeaderMessageType) {
 	case eventSubMessageTypeRevokation:
+		handleEventSubRevocation(message.Subscription)
 		w.WriteHeader(http.StatusNoContent)
 		return
 
@@ -119,13 +321,103 @@ func handleEventsubPush(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	switch message.Subscription.Type {
+	if !claimEventSubMessage(r.Header.Get(eventSubHeaderMessageID)) {
+		// Retried delivery we already handled: acknowledge without alerting again
+		logger.Debug("Skipping already handled eventsub message")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := handleEventSubNotification(message.Subscription.Type, message.Event); err != nil {
+		// Release the claim so the retry of the message gets handled
+		releaseEventSubMessage(r.Header.Get(eventSubHeaderMessageID))
+
+		logger.WithError(err).Error("Unable to handle eventsub notification")
+		http.Error(w, errors.Wrap(err, "handling notification").Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// claimEventSubMessage records the message ID before handling the
+// message so the ID is persisted with the store saved by the handler.
+// Returns false if the message was already claimed by a previous or
+// concurrent delivery.
+func claimEventSubMessage(id string) bool {
+	var claimed bool
+
+	store.WithModLock(func() error {
+		if str.StringInSlice(id, store.EventSubMessageIDs) {
+			return nil
+		}
+
+		store.EventSubMessageIDs = append([]string{id}, store.EventSubMessageIDs...)
+		claimed = true
+		return nil
+	})
+
+	return claimed
+}
+
+// releaseEventSubMessage removes the claim of a message which could not
+// be handled in order to process it when it is delivered again
+func releaseEventSubMessage(id string) {
+	store.WithModLock(func() error {
+		var ids []string
+		for _, seen := range store.EventSubMessageIDs {
+			if seen != id {
+				ids = append(ids, seen)
+			}
+		}
+		store.EventSubMessageIDs = ids
+		return nil
+	})
+
+	// The handler might already have persisted the claim
+	if err := store.Save(cfg.StoreFile); err != nil {
+		log.WithError(err).Error("Unable to update persistent store")
+	}
+}
+
+// handleEventSubNotification dispatches a notification received through
+// any of the EventSub transports and updates the store accordingly
+func handleEventSubNotification(subType string, event json.RawMessage) error {
+	logger := log.WithField("type", subType)
+
+	switch subType {
+	case "channel.ban":
+		var evt eventSubEventBan
+		if err := json.Unmarshal(event, &evt); err != nil {
+			return errors.Wrap(err, "decoding event payload")
+		}
+
+		logger = logger.WithField("name", evt.UserLogin)
+
+		if !evt.IsPermanent {
+			logger.Debug("User got timed out, not purging")
+			return nil
+		}
+
+		purgeBannedUser(evt.UserID, evt.UserLogin, evt.UserName)
+
+	case "channel.cheer":
+		var evt eventSubEventCheer
+		if err := json.Unmarshal(event, &evt); err != nil {
+			return errors.Wrap(err, "decoding event payload")
+		}
+
+		displayName := evt.UserName
+		if evt.IsAnonymous {
+			displayName = "Anonymous"
+		}
+
+		if !bitDonations.Process(bitDonationSourceEventSub, evt.UserID, evt.UserLogin, displayName, evt.Bits, evt.Message) {
+			return nil
+		}
+
 	case "channel.follow":
 		var evt eventSubEventFollow
-		if err := json.Unmarshal(message.Event, &evt); err != nil {
-			log.WithError(err).Errorf("Unable to decode eventsub event payload")
-			http.Error(w, errors.Wrap(err, "parsing message").Error(), http.StatusBadRequest)
-			return
+		if err := json.Unmarshal(event, &evt); err != nil {
+			return errors.Wrap(err, "decoding event payload")
 		}
 
 		logger = logger.WithField("name", evt.UserLogin)
@@ -136,15 +428,20 @@ func handleEventsubPush(w http.ResponseWriter, r *http.Request) {
 			return nil
 		})
 
-		if isKnown {
-			logger.WithField("name", evt.UserLogin).Debug("New follower already known, skipping")
-			return
+		if !knownFollowers.Add(evt.UserID) || isKnown {
+			logger.Debug("New follower already known, skipping")
+			return nil
+		}
+
+		if err := knownFollowers.Save(); err != nil {
+			logger.WithError(err).Error("Unable to save known followers")
 		}
 
 		fields := map[string]interface{}{
 			"from":        evt.UserLogin,
 			"followed_at": evt.FollowedAt,
 		}
+		userProfiles.Enrich(fields, evt.UserID, evt.UserLogin)
 
 		if err := subscriptions.SendAllSockets(msgTypeFollow, fields, false, true); err != nil {
 			log.WithError(err).Error("Unable to send update to all sockets")
@@ -155,13 +452,512 @@ func handleEventsubPush(w http.ResponseWriter, r *http.Request) {
 			store.Followers.Last = &evt.UserLogin
 			store.Followers.Count++
 			store.Followers.Seen = append([]string{evt.UserLogin}, store.Followers.Seen...)
+			if store.Session.Live {
+				store.Session.Follows++
+			}
+
+			return nil
+		})
+
+	case "channel.channel_points_custom_reward_redemption.add":
+		var evt eventSubEventRedemption
+		if err := json.Unmarshal(event, &evt); err != nil {
+			return errors.Wrap(err, "decoding event payload")
+		}
+
+		logger = logger.WithField("name", evt.UserName)
+
+		fields := map[string]interface{}{
+			"id":           evt.ID,
+			"from":         evt.UserName,
+			"from_id":      evt.UserID,
+			"from_login":   evt.UserLogin,
+			"reward_id":    evt.Reward.ID,
+			"reward_title": evt.Reward.Title,
+			"cost":         evt.Reward.Cost,
+			"input":        evt.UserInput,
+			"status":       evt.Status,
+		}
+		userProfiles.Enrich(fields, evt.UserID, evt.UserLogin)
+
+		store.WithModLock(func() error {
+			store.Redemptions.Recent = append([]redemption{{
+				ID:          evt.ID,
+				RewardID:    evt.Reward.ID,
+				RewardTitle: evt.Reward.Title,
+				Cost:        evt.Reward.Cost,
+				User:        evt.UserName,
+				Input:       evt.UserInput,
+				Status:      evt.Status,
+				RedeemedAt:  evt.RedeemedAt,
+			}}, store.Redemptions.Recent...)
+
+			return nil
+		})
+
+		logger.WithFields(log.Fields(fields)).Info("New channel-points redemption")
+		if err := subscriptions.SendAllSockets(msgTypeRedemption, fields, false, true); err != nil {
+			log.WithError(err).Error("Unable to send update to all sockets")
+		}
+
+	case "channel.goal.begin", "channel.goal.progress", "channel.goal.end":
+		var evt eventSubEventGoal
+		if err := json.Unmarshal(event, &evt); err != nil {
+			return errors.Wrap(err, "decoding event payload")
+		}
+
+		logger = logger.WithFields(log.Fields{
+			"goal":    evt.Type,
+			"current": evt.CurrentAmount,
+			"target":  evt.TargetAmount,
+		})
+
+		store.WithModLock(func() error {
+			if subType == "channel.goal.end" {
+				removeGoal(evt.ID)
+				return nil
+			}
+
+			setGoal(goal{
+				ID:          evt.ID,
+				Source:      goalSourceTwitch,
+				Type:        evt.Type,
+				Description: evt.Description,
+				Current:     float64(evt.CurrentAmount),
+				Target:      float64(evt.TargetAmount),
+				StartedAt:   evt.StartedAt,
+			})
+			return nil
+		})
+
+		logger.Info("Creator goal update")
+
+	case "channel.hype_train.begin", "channel.hype_train.progress", "channel.hype_train.end":
+		var evt eventSubEventHypeTrain
+		if err := json.Unmarshal(event, &evt); err != nil {
+			return errors.Wrap(err, "decoding event payload")
+		}
+
+		var (
+			msgType    = msgTypeHypeTrainProgress
+			storeEvent = false
+			train      hypeTrain
+		)
+
+		switch subType {
+		case "channel.hype_train.begin":
+			msgType = msgTypeHypeTrainBegin
+			storeEvent = true
+		case "channel.hype_train.end":
+			msgType = msgTypeHypeTrainEnd
+			storeEvent = true
+		}
+
+		store.WithModLock(func() error {
+			if store.HypeTrain.ID != evt.ID {
+				store.HypeTrain = hypeTrain{ID: evt.ID}
+			}
+
+			store.HypeTrain.Active = evt.EndedAt == nil
+			store.HypeTrain.Level = evt.Level
+			store.HypeTrain.Total = evt.Total
+			store.HypeTrain.TopContributions = nil
+			for _, c := range evt.TopContributions {
+				store.HypeTrain.TopContributions = append(store.HypeTrain.TopContributions, hypeTrainContribution{
+					User:  c.UserName,
+					Type:  c.Type,
+					Total: c.Total,
+				})
+			}
+
+			if evt.Goal > 0 {
+				// End events do not carry goal / progress, keep the last known state
+				store.HypeTrain.Progress = evt.Progress
+				store.HypeTrain.Goal = evt.Goal
+			}
+
+			if evt.StartedAt != nil {
+				store.HypeTrain.StartedAt = evt.StartedAt
+			}
+			if evt.ExpiresAt != nil {
+				store.HypeTrain.ExpiresAt = evt.ExpiresAt
+			}
+			store.HypeTrain.EndedAt = evt.EndedAt
+			store.HypeTrain.CooldownEndsAt = evt.CooldownEndsAt
+
+			train = store.HypeTrain
+			return nil
+		})
+
+		logger.WithFields(log.Fields{
+			"level":    train.Level,
+			"progress": train.Progress,
+			"goal":     train.Goal,
+		}).Info("Hype train update")
+		if err := subscriptions.SendAllSockets(msgType, train, false, storeEvent); err != nil {
+			log.WithError(err).Error("Unable to send update to all sockets")
+		}
+
+	case "channel.poll.begin", "channel.poll.progress", "channel.poll.end":
+		var evt eventSubEventPoll
+		if err := json.Unmarshal(event, &evt); err != nil {
+			return errors.Wrap(err, "decoding event payload")
+		}
+
+		var (
+			msgType    = msgTypePollProgress
+			storeEvent = false
+			p          = poll{
+				ID:        evt.ID,
+				Title:     evt.Title,
+				Status:    evt.Status,
+				Choices:   evt.Choices,
+				StartedAt: evt.StartedAt,
+				EndsAt:    evt.EndsAt,
+				EndedAt:   evt.EndedAt,
+			}
+		)
+
+		switch subType {
+		case "channel.poll.begin":
+			msgType = msgTypePollBegin
+			storeEvent = true
+		case "channel.poll.end":
+			msgType = msgTypePollEnd
+			storeEvent = true
+		}
+
+		if p.Status == "" {
+			// Only the end event carries a status
+			p.Status = "active"
+		}
+
+		store.WithModLock(func() error {
+			store.Poll = &p
+			return nil
+		})
+
+		logger.WithFields(log.Fields{
+			"title":  p.Title,
+			"status": p.Status,
+		}).Info("Poll update")
+		if err := subscriptions.SendAllSockets(msgType, p, false, storeEvent); err != nil {
+			log.WithError(err).Error("Unable to send update to all sockets")
+		}
+
+	case "channel.prediction.begin", "channel.prediction.progress", "channel.prediction.lock", "channel.prediction.end":
+		var evt eventSubEventPrediction
+		if err := json.Unmarshal(event, &evt); err != nil {
+			return errors.Wrap(err, "decoding event payload")
+		}
+
+		var (
+			msgType    = msgTypePredictionProgress
+			storeEvent = false
+			p          = prediction{
+				ID:               evt.ID,
+				Title:            evt.Title,
+				Status:           evt.Status,
+				Outcomes:         evt.Outcomes,
+				WinningOutcomeID: evt.WinningOutcomeID,
+				StartedAt:        evt.StartedAt,
+				LocksAt:          evt.LocksAt,
+				LockedAt:         evt.LockedAt,
+				EndedAt:          evt.EndedAt,
+			}
+		)
+
+		switch subType {
+		case "channel.prediction.begin":
+			msgType = msgTypePredictionBegin
+			storeEvent = true
+		case "channel.prediction.lock":
+			msgType = msgTypePredictionLock
+			p.Status = "locked"
+		case "channel.prediction.end":
+			msgType = msgTypePredictionEnd
+			storeEvent = true
+		}
+
+		if p.Status == "" {
+			// Only lock and end events carry a status
+			p.Status = "active"
+		}
+
+		store.WithModLock(func() error {
+			store.Prediction = &p
+			return nil
+		})
+
+		logger.WithFields(log.Fields{
+			"title":  p.Title,
+			"status": p.Status,
+		}).Info("Prediction update")
+		if err := subscriptions.SendAllSockets(msgType, p, false, storeEvent); err != nil {
+			log.WithError(err).Error("Unable to send update to all sockets")
+		}
+
+	case "channel.raid":
+		var evt eventSubEventRaid
+		if err := json.Unmarshal(event, &evt); err != nil {
+			return errors.Wrap(err, "decoding event payload")
+		}
+
+		logger = logger.WithField("name", evt.FromBroadcasterUserName)
+
+		ctx, cancel := context.WithTimeout(context.Background(), twitchRequestTimeout)
+		defer cancel()
+
+		channel, err := getTwitchChannelInfo(ctx, evt.FromBroadcasterUserID)
+		if err != nil {
+			// Raid is announced nevertheless, just without the channel details
+			logger.WithError(err).Error("Unable to fetch raider channel info")
+		}
+
+		fields := map[string]interface{}{
+			"from":        evt.FromBroadcasterUserName,
+			"from_id":     evt.FromBroadcasterUserID,
+			"viewerCount": evt.Viewers,
+			"game":        channel.GameName,
+			"title":       channel.Title,
+		}
+		userProfiles.Enrich(fields, evt.FromBroadcasterUserID, evt.FromBroadcasterUserLogin)
+
+		store.WithModLock(func() error {
+			store.Raids.Recent = append([]raid{{
+				Name:    evt.FromBroadcasterUserName,
+				Viewers: evt.Viewers,
+				Game:    channel.GameName,
+				Title:   channel.Title,
+				Time:    time.Now(),
+			}}, store.Raids.Recent...)
+			if store.Session.Live {
+				store.Session.Raids++
+			}
+
+			return nil
+		})
+
+		logger.WithFields(log.Fields(fields)).Info("Incoming raid")
+		if err := subscriptions.SendAllSockets(msgTypeRaid, fields, false, true); err != nil {
+			log.WithError(err).Error("Unable to send update to all sockets")
+		}
 
+		if cfg.RaidShoutout {
+			shoutouts.Enqueue(evt.FromBroadcasterUserID, evt.FromBroadcasterUserName)
+		}
+
+		if err := announceRaidInChat(fields); err != nil {
+			logger.WithError(err).Error("Unable to announce raid in chat")
+		}
+
+	case "channel.subscribe":
+		var evt eventSubEventSubscribe
+		if err := json.Unmarshal(event, &evt); err != nil {
+			return errors.Wrap(err, "decoding event payload")
+		}
+
+		logger = logger.WithField("name", evt.UserName)
+
+		store.WithModLock(func() error {
+			store.Subs.Last = &evt.UserName
+			store.Subs.LastDuration = 1
+			store.Subs.Recent = append([]subscriber{{
+				Name:   evt.UserName,
+				Login:  evt.UserLogin,
+				UserID: evt.UserID,
+				Months: 1,
+			}}, store.Subs.Recent...)
+
+			return nil
+		})
+
+		if evt.IsGift {
+			// Gifted subs are announced and counted through the
+			// channel.subscription.gift event which carries the gifter,
+			// the recipient only updates the store
+			logger.Info("New sub-gift recipient")
+			break
+		}
+
+		store.WithModLock(func() error {
+			if store.Session.Live {
+				store.Session.Subs++
+			}
 			return nil
 		})
 
+		fields := map[string]interface{}{
+			"from":       evt.UserName,
+			"from_id":    evt.UserID,
+			"from_login": evt.UserLogin,
+			"is_resub":   false,
+			"paid_for":   1,
+			"streak":     1,
+			"tier":       evt.Tier,
+			"total":      1,
+		}
+		userProfiles.Enrich(fields, evt.UserID, evt.UserLogin)
+
+		logger.WithFields(log.Fields(fields)).Info("New subscriber")
+		if err := subscriptions.SendAllSockets(msgTypeSub, fields, false, true); err != nil {
+			log.WithError(err).Error("Unable to send update to all sockets")
+		}
+
+	case "channel.subscription.gift":
+		var evt eventSubEventSubscriptionGift
+		if err := json.Unmarshal(event, &evt); err != nil {
+			return errors.Wrap(err, "decoding event payload")
+		}
+
+		fields := map[string]interface{}{
+			"from":     evt.UserName,
+			"is_anon":  evt.IsAnonymous,
+			"amount":   evt.Total,
+			"paid_for": 1,
+			"tier":     evt.Tier,
+		}
+
+		if evt.CumulativeTotal != nil {
+			// Only present if the gifter shares the number of gifted subs
+			fields["cumulative_total"] = *evt.CumulativeTotal
+		}
+
+		if !evt.IsAnonymous {
+			fields["from_id"] = evt.UserID
+			fields["from_login"] = evt.UserLogin
+			userProfiles.Enrich(fields, evt.UserID, evt.UserLogin)
+		}
+
+		store.WithModLock(func() error {
+			if store.Session.Live {
+				store.Session.SubGifts += evt.Total
+			}
+			return nil
+		})
+
+		logger.WithFields(log.Fields(fields)).Info("New sub-gift")
+		if err := subscriptions.SendAllSockets(msgTypeSubGift, fields, false, true); err != nil {
+			log.WithError(err).Error("Unable to send update to all sockets")
+		}
+
+	case "channel.subscription.message":
+		var evt eventSubEventSubscriptionMessage
+		if err := json.Unmarshal(event, &evt); err != nil {
+			return errors.Wrap(err, "decoding event payload")
+		}
+
+		logger = logger.WithField("name", evt.UserName)
+
+		fields := map[string]interface{}{
+			"from":       evt.UserName,
+			"from_id":    evt.UserID,
+			"from_login": evt.UserLogin,
+			"is_resub":   true,
+			"paid_for":   evt.DurationMonths,
+			"tier":       evt.Tier,
+			"total":      evt.CumulativeMonths,
+		}
+
+		if evt.Message.Text != "" {
+			fields["message"] = evt.Message.Text
+		}
+
+		if evt.StreakMonths != nil {
+			fields["streak"] = *evt.StreakMonths
+		}
+
+		userProfiles.Enrich(fields, evt.UserID, evt.UserLogin)
+
+		store.WithModLock(func() error {
+			store.Subs.Last = &evt.UserName
+			store.Subs.LastDuration = evt.CumulativeMonths
+			store.Subs.Recent = append([]subscriber{{
+				Name:   evt.UserName,
+				Login:  evt.UserLogin,
+				UserID: evt.UserID,
+				Months: evt.CumulativeMonths,
+			}}, store.Subs.Recent...)
+			if store.Session.Live {
+				store.Session.Subs++
+			}
+
+			return nil
+		})
+
+		logger.WithFields(log.Fields(fields)).Info("New subscriber")
+		if err := subscriptions.SendAllSockets(msgTypeSub, fields, false, true); err != nil {
+			log.WithError(err).Error("Unable to send update to all sockets")
+		}
+
+	case "channel.update":
+		var evt eventSubEventChannelUpdate
+		if err := json.Unmarshal(event, &evt); err != nil {
+			return errors.Wrap(err, "decoding event payload")
+		}
+
+		fields := map[string]interface{}{
+			"title":         evt.Title,
+			"language":      evt.Language,
+			"category_id":   evt.CategoryID,
+			"category_name": evt.CategoryName,
+		}
+
+		store.WithModLock(func() error {
+			store.Channel.Title = evt.Title
+			store.Channel.Language = evt.Language
+			store.Channel.CategoryID = evt.CategoryID
+			store.Channel.CategoryName = evt.CategoryName
+			return nil
+		})
+
+		logger.WithFields(log.Fields(fields)).Info("Channel metadata updated")
+		if err := subscriptions.SendAllSockets(msgTypeChannelUpdate, fields, false, false); err != nil {
+			log.WithError(err).Error("Unable to send update to all sockets")
+		}
+
+	case "stream.offline":
+		var evt eventSubEventStreamOffline
+		if err := json.Unmarshal(event, &evt); err != nil {
+			return errors.Wrap(err, "decoding event payload")
+		}
+
+		store.WithModLock(func() error {
+			now := time.Now()
+			store.Session.Live = false
+			store.Session.EndedAt = &now
+			return nil
+		})
+
+		logger.Info("Stream went offline")
+
+	case "stream.online":
+		var evt eventSubEventStreamOnline
+		if err := json.Unmarshal(event, &evt); err != nil {
+			return errors.Wrap(err, "decoding event payload")
+		}
+
+		store.WithModLock(func() error {
+			if store.Session.ID == evt.ID {
+				// Stream reconnected, keep the counts of the session
+				store.Session.Live = true
+				store.Session.EndedAt = nil
+				return nil
+			}
+
+			store.Session = streamSession{
+				ID:        evt.ID,
+				Live:      true,
+				StartedAt: &evt.StartedAt,
+			}
+			return nil
+		})
+
+		logger.WithField("started_at", evt.StartedAt).Info("Stream went online, new session started")
+
 	default:
-		logger.Warn("Received unexpected webhook request")
-		return
+		logger.Warn("Received unexpected eventsub notification")
+		return nil
 
 	}
 
@@ -172,57 +968,98 @@ func handleEventsubPush(w http.ResponseWriter, r *http.Request) {
 	if err := store.WithModRLock(func() error { return subscriptions.SendAllSockets(msgTypeStore, store, false, false) }); err != nil {
 		logger.WithError(err).Error("Unable to send update to all sockets")
 	}
+
+	return nil
 }
 
 func registerEventSubHooks() error {
-	hookURL := strings.Join([]string{
-		strings.TrimRight(cfg.BaseURL, "/"),
-		"api", "eventsub",
-	}, "/")
-
-	ctx, cancel := context.WithTimeout(context.Background(), twitchRequestTimeout)
-	defer cancel()
-	accessToken, err := getTwitchAppAccessToken(ctx)
-	if err != nil {
-		return errors.Wrap(err, "getting app-access-token")
-	}
+	return registerEventSubSubscriptions(eventSubWebhookTransport(), helixAuthApp)
+}
 
-	// List existing subscriptions
-	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.twitch.tv/helix/eventsub/subscriptions", nil)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Client-Id", cfg.TwitchClient)
-	req.Header.Set("Authorization", "Bearer "+accessToken)
+// eventSubTypes contains all subscription types registered for the channel
+var eventSubTypes = []string{
+	"channel.ban",
+	"channel.channel_points_custom_reward_redemption.add",
+	"channel.cheer",
+	"channel.follow",
+	"channel.goal.begin",
+	"channel.goal.end",
+	"channel.goal.progress",
+	"channel.hype_train.begin",
+	"channel.hype_train.end",
+	"channel.hype_train.progress",
+	"channel.poll.begin",
+	"channel.poll.end",
+	"channel.poll.progress",
+	"channel.prediction.begin",
+	"channel.prediction.end",
+	"channel.prediction.lock",
+	"channel.prediction.progress",
+	"channel.raid",
+	"channel.subscribe",
+	"channel.subscription.gift",
+	"channel.subscription.message",
+	"channel.update",
+	"stream.offline",
+	"stream.online",
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return errors.Wrap(err, "requesting subscribscriptions")
+// eventSubRegistrationError contains the subscription types which
+// could not be created while all others were registered
+type eventSubRegistrationError struct {
+	Failed map[string]error
+}
+
+func (e eventSubRegistrationError) Error() string {
+	var types []string
+	for event := range e.Failed {
+		types = append(types, event)
 	}
-	defer resp.Body.Close()
+	sort.Strings(types)
 
-	var subscriptionList struct {
-		Data []eventSubSubscription
+	var msgs []string
+	for _, event := range types {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", event, e.Failed[event]))
 	}
 
-	if err = json.NewDecoder(resp.Body).Decode(&subscriptionList); err != nil {
-		return errors.Wrap(err, "decoding subscription list")
+	return fmt.Sprintf("registering %d subscription(s) failed: %s", len(types), strings.Join(msgs, "; "))
+}
+
+// isEventSubRegistrationError checks whether only single subscription
+// types failed to register
+func isEventSubRegistrationError(err error) bool {
+	var rErr eventSubRegistrationError
+	return errors.As(err, &rErr)
+}
+
+// registerEventSubSubscriptions creates all subscriptions we want to
+// receive through the given transport which do not yet exist. Failing
+// types are recorded in the status and do not prevent the registration
+// of the other types, an eventSubRegistrationError is returned for them.
+func registerEventSubSubscriptions(transport eventSubTransport, auth helixAuth) error {
+	// List existing subscriptions
+	subscriptionList, err := listEventSubSubscriptions(auth)
+	if err != nil {
+		return errors.Wrap(err, "listing subscriptions")
 	}
 
+	failed := make(map[string]error)
+
 	// Register subscriptions
-	for _, event := range []string{
-		"channel.follow",
-	} {
+	for _, event := range eventSubTypes {
 		var (
 			logger             = log.WithField("event", event)
 			subscriptionExists bool
 		)
 
 		for _, sub := range subscriptionList.Data {
-			if str.StringInSlice(sub.Status, []string{eventSubStatusEnabled, eventSubStatusVerificationPending}) && sub.Transport.Callback == hookURL && sub.Type == event {
+			if sub.isOwn() && str.StringInSlice(sub.Status, []string{eventSubStatusEnabled, eventSubStatusVerificationPending}) && sub.Transport.Matches(transport) && sub.Type == event {
 				logger = logger.WithFields(log.Fields{
 					"id":     sub.ID,
 					"status": sub.Status,
 				})
 				subscriptionExists = true
+				eventSubStatus.Set(event, sub.ID, sub.Status, "")
 			}
 		}
 
@@ -232,50 +1069,94 @@ func registerEventSubHooks() error {
 		}
 
 		payload := eventSubSubscription{
-			Type:    event,
-			Version: "1",
-			Condition: eventSubCondition{
-				BroadcasterUserID: cfg.TwitchID,
-			},
-			Transport: eventSubTransport{
-				Method:   "webhook",
-				Callback: hookURL,
-				Secret:   cfg.WebHookSecret,
-			},
-		}
-
-		buf := new(bytes.Buffer)
-		if err := json.NewEncoder(buf).Encode(payload); err != nil {
-			return errors.Wrap(err, "assemble subscribe payload")
+			Type:      event,
+			Version:   eventSubVersionForType(event),
+			Condition: eventSubConditionForType(event),
+			Transport: transport,
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), twitchRequestTimeout)
-		defer cancel()
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.twitch.tv/helix/eventsub/subscriptions", buf)
-		if err != nil {
-			return errors.Wrap(err, "creating subscribe request")
+		var created struct {
+			Data []eventSubSubscription `json:"data"`
 		}
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Client-Id", cfg.TwitchClient)
-		req.Header.Set("Authorization", "Bearer "+accessToken)
 
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return errors.Wrap(err, "requesting subscribe")
+		if err = helix.Do(context.Background(), helixRequest{
+			Method: http.MethodPost,
+			Path:   "eventsub/subscriptions",
+			Auth:   auth,
+			Body:   payload,
+			Expect: http.StatusAccepted,
+		}, &created); err != nil {
+			eventSubStatus.Set(event, "", eventSubStatusFailed, err.Error())
+			logger.WithError(err).Error("Unable to register eventsub subscription")
+			failed[event] = err
+			continue
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusAccepted {
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				return errors.Wrapf(err, "unexpected status %d, unable to read body", resp.StatusCode)
-			}
-			return errors.Errorf("unexpected status %d: %s", resp.StatusCode, body)
+		for _, sub := range created.Data {
+			eventSubStatus.Set(sub.Type, sub.ID, sub.Status, "")
 		}
 
 		logger.Debug("Registered eventsub subscription")
 	}
 
+	if len(failed) > 0 {
+		return eventSubRegistrationError{Failed: failed}
+	}
+
 	return nil
 }
+
+func eventSubConditionForType(event string) eventSubCondition {
+	switch event {
+	case "channel.follow":
+		// Version 2 requires a moderator of the channel to read followers
+		return eventSubCondition{BroadcasterUserID: cfg.TwitchID, ModeratorUserID: cfg.TwitchID}
+
+	case "channel.raid":
+		// We're interested in incoming raids only
+		return eventSubCondition{ToBroadcasterUserID: cfg.TwitchID}
+
+	default:
+		return eventSubCondition{BroadcasterUserID: cfg.TwitchID}
+	}
+}
+
+// eventSubVersionForType returns the subscription version to register
+// as Twitch removed the version 1 of some types
+func eventSubVersionForType(event string) string {
+	switch event {
+	case "channel.follow":
+		return "2"
+
+	default:
+		return "1"
+	}
+}
+
+func eventSubWebhookTransport() eventSubTransport {
+	return eventSubTransport{
+		Method: "webhook",
+		Callback: strings.Join([]string{
+			strings.TrimRight(cfg.BaseURL, "/"),
+			"api", "eventsub",
+		}, "/"),
+		Secret: cfg.WebHookSecret,
+	}
+}
+
+// isOwn checks whether the subscription is one this service registers
+// for the configured channel as the client ID might also be used for
+// subscriptions of other channels or tools
+func (e eventSubSubscription) isOwn() bool {
+	return str.StringInSlice(e.Type, eventSubTypes) &&
+		e.Version == eventSubVersionForType(e.Type) &&
+		e.Condition == eventSubConditionForType(e.Type)
+}
+
+// Matches checks whether the given transport targets the same
+// destination as this one, ignoring the write-only secret
+func (e eventSubTransport) Matches(other eventSubTransport) bool {
+	return e.Method == other.Method &&
+		e.Callback == other.Callback &&
+		e.SessionID == other.SessionID
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Luzifer/go_helpers/v2/str"
+
+	"github.com/Luzifer/twitch-manager/internal/faketwitch"
+)
+
+func TestEventSubWebhookFollow(t *testing.T) {
+	env := newTestEnv(t, "moderator:read:followers")
+
+	follower := faketwitch.User{
+		ID:              "2000",
+		Login:           "follower",
+		DisplayName:     "Follower",
+		ProfileImageURL: "https://example.com/follower.png",
+		CreatedAt:       time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	env.fake.AddUser(follower)
+
+	// Types requiring other scopes fail to register
+	if err := registerEventSubHooks(); err != nil && !isEventSubRegistrationError(err) {
+		t.Fatalf("registering webhooks: %s", err)
+	}
+
+	waitForEventSubEnabled(t, "channel.follow")
+
+	event := map[string]interface{}{
+		"user_id":                follower.ID,
+		"user_login":             follower.Login,
+		"user_name":              follower.DisplayName,
+		"broadcaster_user_id":    testBroadcaster.ID,
+		"broadcaster_user_login": testBroadcaster.Login,
+		"broadcaster_user_name":  testBroadcaster.DisplayName,
+		"followed_at":            time.Now().Format(time.RFC3339),
+	}
+
+	// Second delivery of a known follower must not alert again
+	for i := 0; i < 2; i++ {
+		if err := env.fake.Notify("channel.follow", event); err != nil {
+			t.Fatalf("delivering follow: %s", err)
+		}
+	}
+
+	follows := env.socketMessages(msgTypeFollow)
+	if len(follows) != 1 {
+		t.Fatalf("expected 1 follow message, got %d", len(follows))
+	}
+
+	fields := follows[0].Payload.(map[string]interface{})
+	if fields["from"] != follower.Login {
+		t.Errorf("expected follow from %q, got %v", follower.Login, fields["from"])
+	}
+	if fields["profile_image_url"] != follower.ProfileImageURL {
+		t.Errorf("expected enriched profile image, got %v", fields["profile_image_url"])
+	}
+
+	if store.Followers.Last == nil || *store.Followers.Last != follower.Login {
+		t.Errorf("expected last follower %q, got %v", follower.Login, store.Followers.Last)
+	}
+	if store.Followers.Count != 1 {
+		t.Errorf("expected follower count 1, got %d", store.Followers.Count)
+	}
+	if !knownFollowers.Has(follower.ID) {
+		t.Error("expected follower to be known")
+	}
+}
+
+func TestEventSubRegistrationContinuesOnFailure(t *testing.T) {
+	newTestEnv(t, "bits:read")
+
+	err := registerEventSubHooks()
+	if !isEventSubRegistrationError(err) {
+		t.Fatalf("expected registration error for types without scope, got %v", err)
+	}
+
+	status := eventSubStatus.Get()
+	if s := status["channel.hype_train.begin"]; s.Status != eventSubStatusFailed || s.Message == "" {
+		t.Errorf("expected hype train subscription to be failed with message, got %+v", s)
+	}
+
+	// Types after a failed one are registered nevertheless
+	waitForEventSubEnabled(t, "channel.cheer", "channel.raid", "stream.online")
+}
+
+// waitForEventSubEnabled waits until the webhook subscriptions of the
+// given types are verified
+func waitForEventSubEnabled(t *testing.T, types ...string) {
+	t.Helper()
+
+	waitFor(t, "webhook verification", func() bool {
+		subs, err := listEventSubSubscriptions(helixAuthApp)
+		if err != nil {
+			return false
+		}
+
+		enabled := map[string]bool{}
+		for _, sub := range subs.Data {
+			enabled[sub.Type] = sub.Status == eventSubStatusEnabled
+		}
+
+		for _, subType := range types {
+			if !enabled[subType] {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestEventSubWebhookDuplicateDelivery(t *testing.T) {
+	env := newTestEnv(t)
+
+	body := `{"subscription":{"type":"channel.follow","version":"2"},"event":{"user_id":"2000","user_login":"follower","user_name":"Follower"}}`
+
+	// Overlapping deliveries of the same message must alert only once
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			postEventSubMessage(t, env, "msg1", body)
+		}()
+	}
+	wg.Wait()
+
+	if l := len(env.socketMessages(msgTypeFollow)); l != 1 {
+		t.Errorf("expected 1 follow message, got %d", l)
+	}
+
+	// The claimed ID is persisted together with the store
+	stored := newStorage()
+	if err := stored.Load(cfg.StoreFile); err != nil {
+		t.Fatalf("loading store: %s", err)
+	}
+	if !str.StringInSlice("msg1", stored.EventSubMessageIDs) {
+		t.Error("expected message ID to be persisted")
+	}
+
+	// Failed messages are released to be handled on retry
+	invalid := `{"subscription":{"type":"channel.cheer","version":"1"},"event":"invalid"}`
+	if code := postEventSubMessage(t, env, "msg2", invalid); code != http.StatusBadRequest {
+		t.Errorf("expected invalid message to be rejected, got status %d", code)
+	}
+	if !claimEventSubMessage("msg2") {
+		t.Error("expected failed message not to be recorded as handled")
+	}
+}
+
+// postEventSubMessage delivers a signed webhook notification and
+// returns the response status
+func postEventSubMessage(t *testing.T, env *testEnv, id, body string) int {
+	t.Helper()
+
+	timestamp := time.Now().UTC().Format(time.RFC3339Nano)
+	mac := hmac.New(sha256.New, []byte(cfg.WebHookSecret))
+	fmt.Fprintf(mac, "%s%s%s", id, timestamp, body)
+
+	req, err := http.NewRequest(http.MethodPost, env.api.URL+"/api/eventsub", strings.NewReader(body))
+	if err != nil {
+		t.Errorf("creating request: %s", err)
+		return 0
+	}
+
+	req.Header.Set(eventSubHeaderMessageID, id)
+	req.Header.Set(eventSubHeaderMessageSignature, fmt.Sprintf("sha256=%x", mac.Sum(nil)))
+	req.Header.Set(eventSubHeaderMessageTimestamp, timestamp)
+	req.Header.Set(eventSubHeaderMessageType, eventSubMessageTypeNotification)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("delivering message: %s", err)
+		return 0
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func TestSubGiftPayloadMatchesDemo(t *testing.T) {
+	env := newTestEnv(t)
+
+	if err := handleEventSubNotification("channel.subscription.gift", json.RawMessage(`{
+		"user_id": "2000", "user_login": "gifter", "user_name": "Gifter",
+		"total": 5, "tier": "1000", "cumulative_total": 12, "is_anonymous": false
+	}`)); err != nil {
+		t.Fatalf("handling sub-gift: %s", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, env.api.URL+"/api/demo/subgift", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("requesting demo: %s", err)
+	}
+	resp.Body.Close()
+
+	gifts := env.socketMessages(msgTypeSubGift)
+	if len(gifts) != 2 {
+		t.Fatalf("expected 2 sub-gift messages, got %d", len(gifts))
+	}
+
+	gift := gifts[0].Payload.(map[string]interface{})
+	if gift["amount"] != int64(5) || gift["cumulative_total"] != int64(12) {
+		t.Errorf("unexpected sub-gift counts: %v", gift)
+	}
+
+	// Identity and profile fields are only known for gift users
+	for _, key := range []string{"from_id", "from_login", "profile_image_url", "broadcaster_type", "account_created_at"} {
+		delete(gift, key)
+	}
+
+	demo := gifts[1].Payload.(map[string]interface{})
+	for key := range gift {
+		if _, ok := demo[key]; !ok {
+			t.Errorf("demo sub-gift is missing key %q", key)
+		}
+	}
+	for key := range demo {
+		if _, ok := gift[key]; !ok {
+			t.Errorf("demo sub-gift has unknown key %q", key)
+		}
+	}
+}
+
+func TestSessionCountersOnlyWhileLive(t *testing.T) {
+	newTestEnv(t)
+
+	sub := func(userID string) {
+		t.Helper()
+
+		if err := handleEventSubNotification("channel.subscribe", json.RawMessage(fmt.Sprintf(
+			`{"user_id": %q, "user_login": "user%[1]s", "user_name": "User%[1]s", "tier": "1000", "is_gift": false}`, userID,
+		))); err != nil {
+			t.Fatalf("handling sub: %s", err)
+		}
+	}
+
+	sub("2000")
+	if store.Session.Subs != 0 {
+		t.Errorf("expected offline sub not to be counted, got %d", store.Session.Subs)
+	}
+
+	if err := handleEventSubNotification("stream.online", json.RawMessage(fmt.Sprintf(
+		`{"id": "stream1", "type": "live", "started_at": %q}`, time.Now().Format(time.RFC3339),
+	))); err != nil {
+		t.Fatalf("handling stream start: %s", err)
+	}
+
+	sub("2001")
+	if store.Session.Subs != 1 {
+		t.Errorf("expected live sub to be counted, got %d", store.Session.Subs)
+	}
+
+	if err := handleEventSubNotification("stream.offline", json.RawMessage(`{}`)); err != nil {
+		t.Fatalf("handling stream end: %s", err)
+	}
+
+	sub("2002")
+	if store.Session.Subs != 1 {
+		t.Errorf("expected sub after the stream not to be counted, got %d", store.Session.Subs)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/Luzifer/go_helpers/v2/str"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type eventSubSubscriptionList struct {
+	Data         []eventSubSubscription `json:"data"`
+	Total        int64                  `json:"total"`
+	TotalCost    int64                  `json:"total_cost"`
+	MaxTotalCost int64                  `json:"max_total_cost"`
+}
+
+// eventSubReconcileLock prevents concurrent reconciliations (timer
+// and resubscription after revocations)
+var eventSubReconcileLock sync.Mutex
+
+// reconcileEventSubSubscriptions removes own subscriptions which are
+// failed or target another transport (i.e. an old callback URL) and
+// creates all missing ones afterwards
+func reconcileEventSubSubscriptions() error {
+	eventSubReconcileLock.Lock()
+	defer eventSubReconcileLock.Unlock()
+
+	log.Debug("Reconciling EventSub subscriptions")
+
+	transport, auth, err := eventSubTargetTransport()
+	if err != nil {
+		return errors.Wrap(err, "getting target transport")
+	}
+
+	subscriptionList, err := listEventSubSubscriptions(auth)
+	if err != nil {
+		return errors.Wrap(err, "listing subscriptions")
+	}
+
+	for _, sub := range subscriptionList.Data {
+		if !sub.isOwn() {
+			// Subscription of another channel or tool
+			continue
+		}
+
+		if sub.Transport.Matches(transport) && str.StringInSlice(sub.Status, []string{eventSubStatusEnabled, eventSubStatusVerificationPending}) {
+			continue
+		}
+
+		logger := log.WithFields(log.Fields{
+			"id":     sub.ID,
+			"status": sub.Status,
+			"type":   sub.Type,
+		})
+
+		if err = deleteEventSubSubscription(sub.ID, auth); err != nil {
+			return errors.Wrapf(err, "deleting subscription %s", sub.ID)
+		}
+
+		logger.Info("Deleted stale EventSub subscription")
+	}
+
+	log.WithFields(log.Fields{
+		"subscriptions": subscriptionList.Total,
+		"total_cost":    subscriptionList.TotalCost,
+		"max_cost":      subscriptionList.MaxTotalCost,
+	}).Info("EventSub subscription cost")
+
+	return errors.Wrap(
+		registerEventSubSubscriptions(transport, auth),
+		"registering subscriptions",
+	)
+}
+
+func deleteEventSubSubscription(id string, auth helixAuth) error {
+	return errors.Wrap(helix.Do(context.Background(), helixRequest{
+		Method: http.MethodDelete,
+		Path:   "eventsub/subscriptions",
+		Params: url.Values{"id": []string{id}},
+		Auth:   auth,
+		Expect: http.StatusNoContent,
+	}, nil), "requesting delete")
+}
+
+// eventSubTargetTransport returns the transport subscriptions should
+// be registered for and the token type to use for managing them
+func eventSubTargetTransport() (eventSubTransport, helixAuth, error) {
+	if cfg.EventSubTransport == "websocket" {
+		sessionID := getEventSubSocketSessionID()
+		if sessionID == "" {
+			return eventSubTransport{}, helixAuthNone, errors.New("no active socket session")
+		}
+
+		return eventSubTransport{Method: "websocket", SessionID: sessionID}, helixAuthUser, nil
+	}
+
+	return eventSubWebhookTransport(), helixAuthApp, nil
+}
+
+// listEventSubSubscriptions fetches all pages of subscriptions visible
+// to the given token
+func listEventSubSubscriptions(auth helixAuth) (eventSubSubscriptionList, error) {
+	var out eventSubSubscriptionList
+
+	err := helix.Paginate(context.Background(), helixRequest{
+		Method: http.MethodGet,
+		Path:   "eventsub/subscriptions",
+		Auth:   auth,
+	}, func(page json.RawMessage) error {
+		var list eventSubSubscriptionList
+		if err := json.Unmarshal(page, &list); err != nil {
+			return errors.Wrap(err, "decoding subscription list")
+		}
+
+		out.Data = append(out.Data, list.Data...)
+		out.Total = list.Total
+		out.TotalCost = list.TotalCost
+		out.MaxTotalCost = list.MaxTotalCost
+
+		return nil
+	})
+
+	return out, errors.Wrap(err, "requesting subscriptions")
+}
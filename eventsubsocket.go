@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	eventSubSocketMessageTypeKeepalive    = "session_keepalive"
+	eventSubSocketMessageTypeNotification = "notification"
+	eventSubSocketMessageTypeReconnect    = "session_reconnect"
+	eventSubSocketMessageTypeRevocation   = "revocation"
+	eventSubSocketMessageTypeWelcome      = "session_welcome"
+
+	eventSubSocketMaxBackoff     = time.Minute
+	eventSubSocketWelcomeTimeout = 10 * time.Second
+)
+
+var (
+	eventSubSocketSessionID     string
+	eventSubSocketSessionIDLock sync.RWMutex
+)
+
+type (
+	eventSubSocketMessage struct {
+		Metadata struct {
+			MessageID           string    `json:"message_id"`
+			MessageType         string    `json:"message_type"`
+			MessageTimestamp    time.Time `json:"message_timestamp"`
+			SubscriptionType    string    `json:"subscription_type"`
+			SubscriptionVersion string    `json:"subscription_version"`
+		} `json:"metadata"`
+		Payload struct {
+			Session      *eventSubSocketSession `json:"session"`
+			Subscription eventSubSubscription   `json:"subscription"`
+			Event        json.RawMessage        `json:"event"`
+		} `json:"payload"`
+	}
+	eventSubSocketSession struct {
+		ID                      string    `json:"id"`
+		Status                  string    `json:"status"`
+		KeepaliveTimeoutSeconds int64     `json:"keepalive_timeout_seconds"`
+		ReconnectURL            string    `json:"reconnect_url"`
+		ConnectedAt             time.Time `json:"connected_at"`
+	}
+
+	// eventSubSocketReconnect carries the result of waiting for the
+	// welcome on the connection to the reconnect URL
+	eventSubSocketReconnect struct {
+		welcome *eventSubSocketMessage
+		err     error
+	}
+)
+
+// runEventSubSocket keeps a connection to the EventSub WebSocket
+// endpoint open and feeds all notifications into the same dispatch
+// used for webhook deliveries. The function never returns.
+func runEventSubSocket(url string) {
+	var (
+		backoff    = 100 * time.Millisecond
+		conn       *websocket.Conn
+		err        error
+		subscribed bool
+		welcome    *eventSubSocketMessage
+	)
+
+	wait := func() {
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > eventSubSocketMaxBackoff {
+			backoff = eventSubSocketMaxBackoff
+		}
+	}
+
+	for {
+		if conn == nil {
+			if conn, _, err = websocket.DefaultDialer.Dial(url, nil); err != nil {
+				log.WithError(err).Error("Unable to connect to EventSub socket")
+				wait()
+				continue
+			}
+			subscribed, welcome = false, nil
+		}
+
+		next, nextWelcome, err := handleEventSubSocket(conn, subscribed, welcome)
+		conn.Close()
+
+		if err != nil {
+			log.WithError(err).Error("EventSub socket connection failed, reconnecting")
+			conn = nil
+			wait()
+			continue
+		}
+
+		// We got reconnected by Twitch: subscriptions are kept on the new
+		// connection so we must not register them again
+		backoff = 100 * time.Millisecond
+		conn, subscribed, welcome = next, true, nextWelcome
+	}
+}
+
+// handleEventSubSocket reads messages from the connection until it
+// fails or Twitch asks us to reconnect. In the latter case the old
+// connection is read until the welcome arrived on the new connection
+// which is then returned together with the welcome message. If a
+// welcome is passed it is handled before reading from the connection.
+func handleEventSubSocket(conn *websocket.Conn, subscribed bool, welcome *eventSubSocketMessage) (*websocket.Conn, *eventSubSocketMessage, error) {
+	var (
+		next      *websocket.Conn
+		reconnect = make(chan eventSubSocketReconnect, 1)
+		timeout   = eventSubSocketWelcomeTimeout
+	)
+
+	for {
+		var msg eventSubSocketMessage
+
+		if welcome != nil {
+			msg, welcome = *welcome, nil
+		} else {
+			if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+				return nil, nil, errors.Wrap(err, "setting read deadline")
+			}
+
+			if err := conn.ReadJSON(&msg); err != nil {
+				if next == nil {
+					return nil, nil, errors.Wrap(err, "reading message")
+				}
+
+				// Old connection is gone while reconnecting: continue
+				// with the new one as soon as it is ready
+				res := <-reconnect
+				if res.err != nil {
+					next.Close()
+					return nil, nil, errors.Wrap(res.err, "waiting for welcome on reconnect URL")
+				}
+
+				return next, res.welcome, nil
+			}
+		}
+
+		logger := log.WithField("type", msg.Metadata.MessageType)
+
+		switch msg.Metadata.MessageType {
+		case eventSubSocketMessageTypeKeepalive:
+			logger.Trace("Received EventSub keepalive")
+
+		case eventSubSocketMessageTypeNotification:
+			logger = logger.WithField("id", msg.Metadata.MessageID)
+
+			if time.Since(msg.Metadata.MessageTimestamp) > eventSubMaxMessageAge {
+				logger.WithField("timestamp", msg.Metadata.MessageTimestamp).Warn("Skipping outdated eventsub message")
+				continue
+			}
+
+			if !claimEventSubMessage(msg.Metadata.MessageID) {
+				logger.Debug("Skipping already handled eventsub message")
+				continue
+			}
+
+			if err := handleEventSubNotification(msg.Payload.Subscription.Type, msg.Payload.Event); err != nil {
+				releaseEventSubMessage(msg.Metadata.MessageID)
+				logger.WithError(err).Error("Unable to handle eventsub notification")
+				continue
+			}
+
+		case eventSubSocketMessageTypeReconnect:
+			if msg.Payload.Session == nil {
+				return nil, nil, errors.New("reconnect message without session")
+			}
+
+			if next != nil {
+				// Already reconnecting
+				continue
+			}
+
+			logger.Debug("EventSub socket reconnect requested")
+
+			var err error
+			if next, _, err = websocket.DefaultDialer.Dial(msg.Payload.Session.ReconnectURL, nil); err != nil {
+				return nil, nil, errors.Wrap(err, "connecting to reconnect URL")
+			}
+
+			go func(next *websocket.Conn) {
+				welcome, err := readEventSubSocketWelcome(next)
+				reconnect <- eventSubSocketReconnect{welcome: welcome, err: err}
+				// Stop reading the old connection, notifications are now
+				// delivered to the new one
+				conn.Close()
+			}(next)
+
+		case eventSubSocketMessageTypeRevocation:
+			handleEventSubRevocation(msg.Payload.Subscription)
+
+		case eventSubSocketMessageTypeWelcome:
+			if msg.Payload.Session == nil {
+				return nil, nil, errors.New("welcome message without session")
+			}
+
+			if msg.Payload.Session.KeepaliveTimeoutSeconds > 0 {
+				// Give Twitch some slack on top of the keepalive timeout
+				timeout = time.Duration(msg.Payload.Session.KeepaliveTimeoutSeconds)*time.Second + eventSubSocketWelcomeTimeout
+			}
+
+			logger.WithField("session", msg.Payload.Session.ID).Debug("EventSub socket session started")
+			setEventSubSocketSessionID(msg.Payload.Session.ID)
+
+			if subscribed {
+				continue
+			}
+
+			switch err := registerEventSubSubscriptions(eventSubTransport{
+				Method:    "websocket",
+				SessionID: msg.Payload.Session.ID,
+			}, helixAuthUser); {
+			case err == nil:
+				// All subscriptions are active
+
+			case isEventSubRegistrationError(err):
+				// Keep the session for the types which were registered,
+				// failed ones are retried by the reconciliation
+				logger.WithError(err).Error("Unable to register some subscriptions")
+
+			default:
+				return nil, nil, errors.Wrap(err, "registering subscriptions")
+			}
+			subscribed = true
+
+		default:
+			logger.Warn("Received unexpected EventSub socket message")
+		}
+	}
+}
+
+// readEventSubSocketWelcome waits for the welcome message which is the
+// first message sent on a new connection
+func readEventSubSocketWelcome(conn *websocket.Conn) (*eventSubSocketMessage, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(eventSubSocketWelcomeTimeout)); err != nil {
+		return nil, errors.Wrap(err, "setting read deadline")
+	}
+
+	msg := new(eventSubSocketMessage)
+	if err := conn.ReadJSON(msg); err != nil {
+		return nil, errors.Wrap(err, "reading message")
+	}
+
+	if msg.Metadata.MessageType != eventSubSocketMessageTypeWelcome {
+		return nil, errors.Errorf("unexpected message type %q", msg.Metadata.MessageType)
+	}
+
+	return msg, nil
+}
+
+func getEventSubSocketSessionID() string {
+	eventSubSocketSessionIDLock.RLock()
+	defer eventSubSocketSessionIDLock.RUnlock()
+
+	return eventSubSocketSessionID
+}
+
+func setEventSubSocketSessionID(id string) {
+	eventSubSocketSessionIDLock.Lock()
+	defer eventSubSocketSessionIDLock.Unlock()
+
+	eventSubSocketSessionID = id
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	eventSubResubscribeInitialBackoff = time.Second
+	eventSubResubscribeMaxBackoff     = 5 * time.Minute
+)
+
+var eventSubStatus = newEventSubStatusStore()
+
+type (
+	eventSubStatusEntry struct {
+		ID        string    `json:"id"`
+		Status    string    `json:"status"`
+		Message   string    `json:"message,omitempty"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+
+	eventSubStatusStore struct {
+		entries       map[string]eventSubStatusEntry
+		lock          sync.RWMutex
+		resubscribing bool
+	}
+)
+
+func newEventSubStatusStore() *eventSubStatusStore {
+	return &eventSubStatusStore{
+		entries: make(map[string]eventSubStatusEntry),
+	}
+}
+
+func (e *eventSubStatusStore) Get() map[string]eventSubStatusEntry {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	out := make(map[string]eventSubStatusEntry, len(e.entries))
+	for k, v := range e.entries {
+		out[k] = v
+	}
+
+	return out
+}
+
+func (e *eventSubStatusStore) Set(subType, id, status, message string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.entries[subType] = eventSubStatusEntry{
+		ID:        id,
+		Status:    status,
+		Message:   message,
+		UpdatedAt: time.Now(),
+	}
+}
+
+// Resubscribe replaces failed subscriptions in the background,
+// retrying with exponential backoff until it succeeds. Multiple calls
+// while a resubscription is running are collapsed into the running one.
+func (e *eventSubStatusStore) Resubscribe() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.resubscribing {
+		return
+	}
+	e.resubscribing = true
+
+	go func() {
+		backoff := eventSubResubscribeInitialBackoff
+
+		for {
+			// Give Twitch a moment to settle after the revocation
+			time.Sleep(backoff)
+
+			err := reconcileEventSubSubscriptions()
+			if err == nil {
+				log.Info("EventSub subscriptions re-created")
+				break
+			}
+
+			if isEventSubRegistrationError(err) {
+				// Single types are not retried here as they will most
+				// likely keep failing for missing scopes, the periodic
+				// reconciliation picks them up
+				log.WithError(err).Warn("EventSub subscriptions partially re-created")
+				break
+			}
+
+			log.WithError(err).WithField("backoff", backoff).Error("Unable to re-create EventSub subscriptions")
+			if backoff *= 2; backoff > eventSubResubscribeMaxBackoff {
+				backoff = eventSubResubscribeMaxBackoff
+			}
+		}
+
+		e.lock.Lock()
+		defer e.lock.Unlock()
+		e.resubscribing = false
+	}()
+}
+
+func handleEventSubStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(eventSubStatus.Get()); err != nil {
+		http.Error(w, errors.Wrap(err, "encoding status").Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleEventSubRevocation is called for revoked subscriptions on
+// any of the EventSub transports
+func handleEventSubRevocation(sub eventSubSubscription) {
+	logger := log.WithFields(log.Fields{
+		"id":     sub.ID,
+		"status": sub.Status,
+		"type":   sub.Type,
+	})
+
+	switch sub.Status {
+	case eventSubStatusAuthorizationRevoked, eventSubStatusUserRemoved:
+		eventSubStatus.Set(sub.Type, sub.ID, sub.Status, "Subscription revoked, authorization needs to be renewed")
+		logger.Error("EventSub subscription was revoked and cannot be re-created: authorization was removed")
+
+	default:
+		// Subscriptions are re-created for the current webhook callback
+		// or socket session through the reconciliation
+		eventSubStatus.Set(sub.Type, sub.ID, sub.Status, "Subscription revoked, re-creating")
+		logger.Warn("EventSub subscription was revoked, re-creating")
+		eventSubStatus.Resubscribe()
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const followerSetFileName = "followers.json.gz"
+
+var knownFollowers = newFollowerSet()
+
+// followerSet contains the IDs of everyone who ever followed the
+// channel and is used to prevent repeated follow alerts. It is kept
+// out of the store as it is not needed by the overlay and might get
+// large for bigger channels.
+type followerSet struct {
+	ids      map[string]struct{}
+	lock     sync.RWMutex
+	saveLock sync.Mutex
+}
+
+func newFollowerSet() *followerSet {
+	return &followerSet{ids: make(map[string]struct{})}
+}
+
+// Add adds the given user IDs and returns whether any of them was new
+func (f *followerSet) Add(ids ...string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var added bool
+	for _, id := range ids {
+		if _, ok := f.ids[id]; !ok {
+			f.ids[id] = struct{}{}
+			added = true
+		}
+	}
+
+	return added
+}
+
+func (f *followerSet) Has(id string) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	_, ok := f.ids[id]
+	return ok
+}
+
+func (f *followerSet) Load() error {
+	fp, err := os.Open(followerSetFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return err
+		}
+		return errors.Wrap(err, "opening follower file")
+	}
+	defer fp.Close()
+
+	gf, err := gzip.NewReader(fp)
+	if err != nil {
+		return errors.Wrap(err, "create gzip reader")
+	}
+	defer gf.Close()
+
+	var ids []string
+	if err = json.NewDecoder(gf).Decode(&ids); err != nil {
+		return errors.Wrap(err, "decode json")
+	}
+
+	f.Add(ids...)
+	return nil
+}
+
+func (f *followerSet) Save() error {
+	f.saveLock.Lock()
+	defer f.saveLock.Unlock()
+
+	f.lock.RLock()
+	ids := make([]string, 0, len(f.ids))
+	for id := range f.ids {
+		ids = append(ids, id)
+	}
+	f.lock.RUnlock()
+
+	// Write to a temporary file and move it into place afterwards so a
+	// crash while writing does not leave a broken file behind
+	tmpFile := followerSetFile() + ".tmp"
+
+	if err := func() error {
+		fp, err := os.Create(tmpFile)
+		if err != nil {
+			return errors.Wrap(err, "create file")
+		}
+		defer fp.Close()
+
+		gf := gzip.NewWriter(fp)
+		if err = json.NewEncoder(gf).Encode(ids); err != nil {
+			return errors.Wrap(err, "encode json")
+		}
+
+		return errors.Wrap(gf.Close(), "closing gzip writer")
+	}(); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	return errors.Wrap(os.Rename(tmpFile, followerSetFile()), "moving file into place")
+}
+
+func followerSetFile() string {
+	return filepath.Join(filepath.Dir(cfg.StoreFile), followerSetFileName)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	goalSourceLocal  = "local"
+	goalSourceTwitch = "twitch"
+
+	goalTypeBits      = "bits"
+	goalTypeDonations = "donations"
+)
+
+type goal struct {
+	ID          string     `json:"id"`
+	Source      string     `json:"source"`
+	Type        string     `json:"type"`
+	Description string     `json:"description"`
+	Current     float64    `json:"current"`
+	Target      float64    `json:"target"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+}
+
+// addLocalGoalProgress adds the amount to the local goal of the given
+// type if there is one. Must be called while holding the store mod-lock.
+func addLocalGoalProgress(goalType string, amount float64) {
+	for i := range store.Goals {
+		if store.Goals[i].Source == goalSourceLocal && store.Goals[i].Type == goalType {
+			store.Goals[i].Current += amount
+		}
+	}
+}
+
+// setGoal replaces the goal with the same ID or adds it to the list of
+// goals. Must be called while holding the store mod-lock.
+func setGoal(g goal) {
+	for i := range store.Goals {
+		if store.Goals[i].ID == g.ID {
+			store.Goals[i] = g
+			return
+		}
+	}
+
+	store.Goals = append(store.Goals, g)
+}
+
+// removeGoal removes the goal with the given ID. Must be called while
+// holding the store mod-lock.
+func removeGoal(id string) {
+	var goals []goal
+	for _, g := range store.Goals {
+		if g.ID != id {
+			goals = append(goals, g)
+		}
+	}
+	store.Goals = goals
+}
+
+// updateCreatorGoals fetches the active creator goals from Twitch and
+// replaces the known ones as goal events might have been missed while
+// the service was not running
+func updateCreatorGoals() error {
+	log.Debug("Updating creator goals from API")
+
+	var payload struct {
+		Data []struct {
+			ID            string    `json:"id"`
+			Type          string    `json:"type"`
+			Description   string    `json:"description"`
+			CurrentAmount int64     `json:"current_amount"`
+			TargetAmount  int64     `json:"target_amount"`
+			CreatedAt     time.Time `json:"created_at"`
+		} `json:"data"`
+	}
+
+	if err := helix.Do(context.Background(), helixRequest{
+		Method: http.MethodGet,
+		Path:   "goals",
+		Params: url.Values{"broadcaster_id": []string{cfg.TwitchID}},
+		Auth:   helixAuthUser,
+	}, &payload); err != nil {
+		return errors.Wrap(err, "requesting goals")
+	}
+
+	store.WithModLock(func() error {
+		var goals []goal
+		for _, g := range store.Goals {
+			if g.Source != goalSourceTwitch {
+				goals = append(goals, g)
+			}
+		}
+		store.Goals = goals
+
+		for _, g := range payload.Data {
+			startedAt := g.CreatedAt
+			setGoal(goal{
+				ID:          g.ID,
+				Source:      goalSourceTwitch,
+				Type:        normalizeTwitchGoalType(g.Type),
+				Description: g.Description,
+				Current:     float64(g.CurrentAmount),
+				Target:      float64(g.TargetAmount),
+				StartedAt:   &startedAt,
+			})
+		}
+
+		return nil
+	})
+
+	return errors.Wrap(store.Save(cfg.StoreFile), "save store")
+}
+
+// normalizeTwitchGoalType maps the goal types of the Helix API to the
+// ones used in EventSub notifications as both are stored in the same
+// list of goals
+func normalizeTwitchGoalType(goalType string) string {
+	if goalType == "follower" {
+		return "follow"
+	}
+	return goalType
+}
+
+func handleDeleteLocalGoal(w http.ResponseWriter, r *http.Request) {
+	goalType := mux.Vars(r)["type"]
+
+	store.WithModLock(func() error {
+		removeGoal(goalSourceLocal + "-" + goalType)
+		return nil
+	})
+
+	if err := store.Save(cfg.StoreFile); err != nil {
+		log.WithError(err).Error("Unable to update persistent store")
+	}
+
+	if err := store.WithModRLock(func() error { return subscriptions.SendAllSockets(msgTypeStore, store, false, false) }); err != nil {
+		log.WithError(err).Error("Unable to send update to all sockets")
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func handleSetLocalGoal(w http.ResponseWriter, r *http.Request) {
+	var (
+		goalType = mux.Vars(r)["type"]
+		payload  struct {
+			Current     *float64 `json:"current"`
+			Description string   `json:"description"`
+			Target      float64  `json:"target"`
+		}
+	)
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, errors.Wrap(err, "parse request body").Error(), http.StatusBadRequest)
+		return
+	}
+
+	if payload.Target <= 0 {
+		http.Error(w, "target must be positive", http.StatusBadRequest)
+		return
+	}
+
+	store.WithModLock(func() error {
+		var (
+			id  = goalSourceLocal + "-" + goalType
+			now = time.Now()
+			g   = goal{
+				ID:          id,
+				Source:      goalSourceLocal,
+				Type:        goalType,
+				Description: payload.Description,
+				Target:      payload.Target,
+				StartedAt:   &now,
+			}
+		)
+
+		for _, existing := range store.Goals {
+			if existing.ID == id {
+				// Keep progress when only adjusting the target
+				g.Current = existing.Current
+				g.StartedAt = existing.StartedAt
+			}
+		}
+
+		if payload.Current != nil {
+			g.Current = *payload.Current
+		}
+
+		setGoal(g)
+		return nil
+	})
+
+	if err := store.Save(cfg.StoreFile); err != nil {
+		log.WithError(err).Error("Unable to update persistent store")
+	}
+
+	if err := store.WithModRLock(func() error { return subscriptions.SendAllSockets(msgTypeStore, store, false, false) }); err != nil {
+		log.WithError(err).Error("Unable to send update to all sockets")
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	helixMaxRetries       = 5
+	helixRetryBaseBackoff = 500 * time.Millisecond
+)
+
+const (
+	helixAuthNone helixAuth = iota
+	helixAuthApp
+	helixAuthUser
+)
+
+var helix *helixClient
+
+type (
+	// helixAuth selects which token to send along with a request
+	helixAuth int
+
+	helixClient struct {
+		apiBase  string
+		idBase   string
+		clientID string
+		client   *http.Client
+		// limiters contains one bucket per token type as Twitch
+		// limits every token separately
+		limiters map[helixAuth]*helixRateLimiter
+	}
+
+	// helixError is returned for all responses having an unexpected
+	// status code and carries the response for further inspection
+	helixError struct {
+		Method string
+		URL    string
+		Status int
+		Body   []byte
+	}
+
+	helixRequest struct {
+		Method string
+		Path   string
+		Params url.Values
+		Auth   helixAuth
+		Body   interface{}
+		// Expect contains the expected status code, defaults to 200
+		Expect int
+	}
+)
+
+func newHelixClient(apiBase, idBase, clientID string) *helixClient {
+	return &helixClient{
+		apiBase:  strings.TrimRight(apiBase, "/"),
+		idBase:   strings.TrimRight(idBase, "/"),
+		clientID: clientID,
+		client:   http.DefaultClient,
+		limiters: map[helixAuth]*helixRateLimiter{
+			helixAuthNone: new(helixRateLimiter),
+			helixAuthApp:  new(helixRateLimiter),
+			helixAuthUser: new(helixRateLimiter),
+		},
+	}
+}
+
+func (h helixError) Error() string {
+	return fmt.Sprintf("unexpected status %d for %s %s: %s", h.Status, h.Method, h.URL, h.Body)
+}
+
+// isHelixStatus checks whether the error is a helixError with the given status
+func isHelixStatus(err error, status int) bool {
+	var hErr helixError
+	return errors.As(err, &hErr) && hErr.Status == status
+}
+
+// Do executes the request against the Helix API and decodes the
+// response into out unless out is nil. Every attempt is limited to
+// the twitchRequestTimeout, rate-limits and retries are handled
+// transparently.
+func (h *helixClient) Do(ctx context.Context, req helixRequest, out interface{}) error {
+	u := h.apiBase + "/" + strings.TrimLeft(req.Path, "/")
+	if len(req.Params) > 0 {
+		u = strings.Join([]string{u, req.Params.Encode()}, "?")
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = json.Marshal(req.Body); err != nil {
+			return errors.Wrap(err, "encoding request body")
+		}
+	}
+
+	expect := req.Expect
+	if expect == 0 {
+		expect = http.StatusOK
+	}
+
+	build := func(ctx context.Context) (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, req.Method, u, bytes.NewReader(body))
+		if err != nil {
+			return nil, errors.Wrap(err, "assemble request")
+		}
+		httpReq.Header.Set("Client-Id", h.clientID)
+		if req.Body != nil {
+			httpReq.Header.Set("Content-Type", "application/json")
+		}
+
+		return httpReq, errors.Wrap(h.authorize(ctx, httpReq, req.Auth), "authorizing request")
+	}
+
+	err := h.withRetry(ctx, req.Auth, build, expect, out)
+	if !isHelixStatus(err, http.StatusUnauthorized) {
+		return err
+	}
+
+	// The token might have been revoked before its expiry
+	switch req.Auth {
+	case helixAuthApp:
+		appAccessTokens.Invalidate()
+		err = h.withRetry(ctx, req.Auth, build, expect, out)
+
+	case helixAuthUser:
+		if userAccessTokens.Invalidate() {
+			err = h.withRetry(ctx, req.Auth, build, expect, out)
+		}
+	}
+
+	return err
+}
+
+// Paginate executes the request once for every page of the response
+// and calls fn with the raw JSON of each page
+func (h *helixClient) Paginate(ctx context.Context, req helixRequest, fn func(page json.RawMessage) error) error {
+	params := url.Values{}
+	for k, v := range req.Params {
+		params[k] = v
+	}
+	req.Params = params
+
+	for {
+		var page json.RawMessage
+		if err := h.Do(ctx, req, &page); err != nil {
+			return err
+		}
+
+		if err := fn(page); err != nil {
+			return errors.Wrap(err, "handling page")
+		}
+
+		var pagination struct {
+			Data       []json.RawMessage `json:"data"`
+			Pagination struct {
+				Cursor string `json:"cursor"`
+			} `json:"pagination"`
+		}
+
+		if err := json.Unmarshal(page, &pagination); err != nil {
+			return errors.Wrap(err, "decoding pagination")
+		}
+
+		if pagination.Pagination.Cursor == "" || len(pagination.Data) == 0 {
+			// Some endpoints return a cursor along with the empty last page
+			return nil
+		}
+
+		req.Params.Set("after", pagination.Pagination.Cursor)
+	}
+}
+
+// DoID sends a body-less request to the given path on the id.twitch.tv
+// OAuth2 endpoint. The token is optional and sent in the OAuth format
+// the endpoint expects.
+func (h *helixClient) DoID(ctx context.Context, method, path string, params url.Values, token string, out interface{}) error {
+	return h.withRetry(ctx, helixAuthNone, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, method, h.IDURL(path, params), nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "assemble request")
+		}
+
+		if token != "" {
+			req.Header.Set("Authorization", "OAuth "+token)
+		}
+
+		return req, nil
+	}, http.StatusOK, out)
+}
+
+// IDURL builds an URL to the given path on the id.twitch.tv OAuth2 endpoint
+func (h *helixClient) IDURL(path string, params url.Values) string {
+	u := h.idBase + "/" + strings.TrimLeft(path, "/")
+	if len(params) > 0 {
+		u = strings.Join([]string{u, params.Encode()}, "?")
+	}
+	return u
+}
+
+func (h *helixClient) authorize(ctx context.Context, req *http.Request, auth helixAuth) error {
+	switch auth {
+	case helixAuthNone:
+		return nil
+
+	case helixAuthApp:
+		token, err := appAccessTokens.Get(ctx)
+		if err != nil {
+			return errors.Wrap(err, "getting app-access-token")
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+	case helixAuthUser:
+		token, err := userAccessTokens.Get(ctx)
+		if err != nil {
+			return errors.Wrap(err, "getting user-access-token")
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+	default:
+		return errors.Errorf("unknown auth type %d", auth)
+	}
+
+	return nil
+}
+
+func (h *helixClient) execute(req *http.Request, limiter *helixRateLimiter, expect int, out interface{}) error {
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "executing request")
+	}
+	defer resp.Body.Close()
+
+	limiter.Update(resp.Header)
+
+	if resp.StatusCode != expect {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return errors.Wrapf(err, "unexpected status %d, unable to read body", resp.StatusCode)
+		}
+
+		return helixError{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Status: resp.StatusCode,
+			Body:   body,
+		}
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(out), "decoding response")
+}
+
+// withRetry executes the request built by the given function until it
+// succeeds, the error is not retryable or the retries are exhausted
+func (h *helixClient) withRetry(ctx context.Context, auth helixAuth, build func(context.Context) (*http.Request, error), expect int, out interface{}) error {
+	limiter, ok := h.limiters[auth]
+	if !ok {
+		return errors.Errorf("unknown auth type %d", auth)
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := limiter.Wait(ctx); err != nil {
+			return errors.Wrap(err, "waiting for rate-limit")
+		}
+
+		err := func() error {
+			ctx, cancel := context.WithTimeout(ctx, twitchRequestTimeout)
+			defer cancel()
+
+			req, err := build(ctx)
+			if err != nil {
+				return err
+			}
+
+			return h.execute(req, limiter, expect, out)
+		}()
+
+		if err == nil || attempt >= helixMaxRetries || !(isHelixStatus(err, http.StatusTooManyRequests) || isHelixServerError(err)) {
+			return err
+		}
+
+		backoff := helixRetryBackoff(attempt)
+		log.WithError(err).WithField("backoff", backoff).Debug("Retrying Twitch API request")
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "waiting for retry")
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// isHelixServerError checks whether the error is a helixError with a 5xx status
+func isHelixServerError(err error) bool {
+	var hErr helixError
+	return errors.As(err, &hErr) && hErr.Status >= http.StatusInternalServerError
+}
+
+// helixRetryBackoff returns an exponential backoff with up to 50% jitter
+func helixRetryBackoff(attempt int) time.Duration {
+	backoff := helixRetryBaseBackoff << uint(attempt)
+	return backoff + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
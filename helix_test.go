@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHelixPaginateStopsOnEmptyPage(t *testing.T) {
+	var requests int32
+
+	// Always returns a cursor, the second page is empty
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := "[]"
+		if atomic.AddInt32(&requests, 1) == 1 {
+			data = `[{"id":"1"}]`
+		}
+
+		fmt.Fprintf(w, `{"data":%s,"pagination":{"cursor":"next"}}`, data)
+	}))
+	defer srv.Close()
+
+	client := newHelixClient(srv.URL, srv.URL, "client")
+
+	var pages int
+	if err := client.Paginate(context.Background(), helixRequest{
+		Method: http.MethodGet,
+		Path:   "subscriptions",
+		Auth:   helixAuthNone,
+	}, func(page json.RawMessage) error {
+		pages++
+		if pages > 2 {
+			return fmt.Errorf("requested page %d", pages)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("paginating: %s", err)
+	}
+
+	if pages != 2 {
+		t.Errorf("expected 2 pages, got %d", pages)
+	}
+}
@@ -0,0 +1,350 @@
+package faketwitch
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	eventSubStatusEnabled             = "enabled"
+	eventSubStatusVerificationFailed  = "webhook_callback_verification_failed"
+	eventSubStatusVerificationPending = "webhook_callback_verification_pending"
+
+	eventSubMaxTotalCost = 10000
+)
+
+type (
+	eventSubSubscription struct {
+		ID        string            `json:"id"`
+		Status    string            `json:"status"`
+		Type      string            `json:"type"`
+		Version   string            `json:"version"`
+		Cost      int64             `json:"cost"`
+		Condition map[string]string `json:"condition"`
+		Transport eventSubTransport `json:"transport"`
+		CreatedAt time.Time         `json:"created_at"`
+		secret    string
+	}
+
+	eventSubTransport struct {
+		Method    string `json:"method"`
+		Callback  string `json:"callback,omitempty"`
+		SessionID string `json:"session_id,omitempty"`
+	}
+)
+
+// eventSubScopes contains the scopes the broadcaster needs to grant
+// to create subscriptions of the given types
+var eventSubScopes = map[string]string{
+	"channel.ban": "channel:moderate",
+	"channel.channel_points_custom_reward_redemption.add": "channel:read:redemptions",
+	"channel.cheer":                "bits:read",
+	"channel.follow":               "moderator:read:followers",
+	"channel.goal.begin":           "channel:read:goals",
+	"channel.goal.end":             "channel:read:goals",
+	"channel.goal.progress":        "channel:read:goals",
+	"channel.hype_train.begin":     "channel:read:hype_train",
+	"channel.hype_train.end":       "channel:read:hype_train",
+	"channel.hype_train.progress":  "channel:read:hype_train",
+	"channel.poll.begin":           "channel:read:polls",
+	"channel.poll.end":             "channel:read:polls",
+	"channel.poll.progress":        "channel:read:polls",
+	"channel.prediction.begin":     "channel:read:predictions",
+	"channel.prediction.end":       "channel:read:predictions",
+	"channel.prediction.lock":      "channel:read:predictions",
+	"channel.prediction.progress":  "channel:read:predictions",
+	"channel.subscribe":            "channel:read:subscriptions",
+	"channel.subscription.gift":    "channel:read:subscriptions",
+	"channel.subscription.message": "channel:read:subscriptions",
+}
+
+// Notify delivers the event to all enabled webhook subscriptions of the
+// given type and returns an error if none accepted it
+func (s *Server) Notify(subType string, event interface{}) error {
+	var delivered int
+
+	for _, sub := range s.eventSubsByType(subType) {
+		if err := s.deliver(sub, "notification", map[string]interface{}{
+			"subscription": sub,
+			"event":        event,
+		}, nil); err != nil {
+			return errors.Wrapf(err, "delivering to subscription %s", sub.ID)
+		}
+		delivered++
+	}
+
+	if delivered == 0 {
+		return errors.Errorf("no enabled webhook subscription for %s", subType)
+	}
+
+	return nil
+}
+
+// Revoke marks all subscriptions of the given type with the status
+// and sends the revocation to webhook subscriptions
+func (s *Server) Revoke(subType, status string) error {
+	for _, sub := range s.eventSubsByType(subType) {
+		s.lock.Lock()
+		for _, stored := range s.eventSubs {
+			if stored.ID == sub.ID {
+				stored.Status = status
+			}
+		}
+		s.lock.Unlock()
+
+		sub.Status = status
+
+		if err := s.deliver(sub, "revocation", map[string]interface{}{
+			"subscription": sub,
+		}, nil); err != nil {
+			return errors.Wrapf(err, "delivering to subscription %s", sub.ID)
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) eventSubsByType(subType string) []eventSubSubscription {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var out []eventSubSubscription
+	for _, sub := range s.eventSubs {
+		if sub.Type == subType && sub.Status == eventSubStatusEnabled && sub.Transport.Method == "webhook" {
+			out = append(out, *sub)
+		}
+	}
+
+	return out
+}
+
+// deliver sends the signed message to the callback of the subscription
+// and stores the response body into out if set
+func (s *Server) deliver(sub eventSubSubscription, msgType string, payload interface{}, out *bytes.Buffer) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "encoding payload")
+	}
+
+	var (
+		id        = newID()
+		timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+		mac       = hmac.New(sha256.New, []byte(sub.secret))
+	)
+	fmt.Fprintf(mac, "%s%s%s", id, timestamp, body)
+
+	req, err := http.NewRequest(http.MethodPost, sub.Transport.Callback, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "assemble request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Twitch-Eventsub-Message-Id", id)
+	req.Header.Set("Twitch-Eventsub-Message-Retry", "0")
+	req.Header.Set("Twitch-Eventsub-Message-Type", msgType)
+	req.Header.Set("Twitch-Eventsub-Message-Signature", fmt.Sprintf("sha256=%x", mac.Sum(nil)))
+	req.Header.Set("Twitch-Eventsub-Message-Timestamp", timestamp)
+	req.Header.Set("Twitch-Eventsub-Subscription-Type", sub.Type)
+	req.Header.Set("Twitch-Eventsub-Subscription-Version", sub.Version)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "executing request")
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "reading response")
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return errors.Errorf("unexpected status %d: %s", resp.StatusCode, respBody)
+	}
+
+	if out != nil {
+		out.Write(respBody)
+	}
+
+	return nil
+}
+
+// verify sends the challenge to the callback and enables the
+// subscription if it was answered correctly
+func (s *Server) verify(sub eventSubSubscription) {
+	var (
+		challenge = newID()
+		resp      = new(bytes.Buffer)
+		status    = eventSubStatusEnabled
+	)
+
+	if err := s.deliver(sub, "webhook_callback_verification", map[string]interface{}{
+		"challenge":    challenge,
+		"subscription": sub,
+	}, resp); err != nil || resp.String() != challenge {
+		status = eventSubStatusVerificationFailed
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, stored := range s.eventSubs {
+		if stored.ID == sub.ID {
+			stored.Status = status
+		}
+	}
+}
+
+// isScopeGranted checks whether any valid user-access-token of the
+// broadcaster carries the scope
+func (s *Server) isScopeGranted(scope string) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, t := range s.tokens {
+		if t.UserID != s.Broadcaster.ID || time.Now().After(t.ExpiresAt) {
+			continue
+		}
+
+		for _, sc := range t.Scopes {
+			if sc == scope {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (s *Server) handleHelixCreateEventSub(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Type      string            `json:"type"`
+		Version   string            `json:"version"`
+		Condition map[string]string `json:"condition"`
+		Transport struct {
+			eventSubTransport
+			Secret string `json:"secret"`
+		} `json:"transport"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		sendError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+
+	t, ok := s.tokenFromHeader(r, "Bearer")
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Invalid OAuth token")
+		return
+	}
+
+	switch payload.Transport.Method {
+	case "webhook":
+		if t.UserID != "" {
+			sendError(w, http.StatusBadRequest, "webhook transport requires an app-access-token")
+			return
+		}
+
+	case "websocket":
+		if t.UserID == "" {
+			sendError(w, http.StatusBadRequest, "websocket transport requires a user-access-token")
+			return
+		}
+
+	default:
+		sendError(w, http.StatusBadRequest, "unsupported transport")
+		return
+	}
+
+	if payload.Type == "channel.follow" && (payload.Version != "2" || payload.Condition["moderator_user_id"] == "") {
+		// Version 1 was removed by Twitch
+		sendError(w, http.StatusBadRequest, "channel.follow requires version 2 with moderator_user_id")
+		return
+	}
+
+	if scope, ok := eventSubScopes[payload.Type]; ok && !s.isScopeGranted(scope) {
+		sendError(w, http.StatusForbidden, "subscription missing proper authorization")
+		return
+	}
+
+	sub := &eventSubSubscription{
+		ID:        newID(),
+		Status:    eventSubStatusEnabled,
+		Type:      payload.Type,
+		Version:   payload.Version,
+		Condition: payload.Condition,
+		Transport: payload.Transport.eventSubTransport,
+		CreatedAt: time.Now(),
+		secret:    payload.Transport.Secret,
+	}
+
+	if sub.Transport.Method == "webhook" {
+		sub.Status = eventSubStatusVerificationPending
+	}
+
+	s.lock.Lock()
+	for _, existing := range s.eventSubs {
+		if existing.Type == sub.Type && existing.Transport == sub.Transport && existing.Status != eventSubStatusVerificationFailed {
+			s.lock.Unlock()
+			sendError(w, http.StatusConflict, "subscription already exists")
+			return
+		}
+	}
+	s.eventSubs = append(s.eventSubs, sub)
+	created := *sub
+	s.lock.Unlock()
+
+	if created.Transport.Method == "webhook" {
+		go s.verify(created)
+	}
+
+	sendJSON(w, http.StatusAccepted, map[string]interface{}{
+		"data":           []eventSubSubscription{created},
+		"total":          1,
+		"total_cost":     0,
+		"max_total_cost": eventSubMaxTotalCost,
+	})
+}
+
+func (s *Server) handleHelixDeleteEventSub(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, sub := range s.eventSubs {
+		if sub.ID == r.FormValue("id") {
+			s.eventSubs = append(s.eventSubs[:i], s.eventSubs[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	sendError(w, http.StatusNotFound, "subscription not found")
+}
+
+func (s *Server) handleHelixListEventSub(w http.ResponseWriter, r *http.Request) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	start, end, cursor := paginate(r, len(s.eventSubs))
+
+	data := []eventSubSubscription{}
+	for _, sub := range s.eventSubs[start:end] {
+		data = append(data, *sub)
+	}
+
+	sendJSON(w, http.StatusOK, map[string]interface{}{
+		"data":           data,
+		"pagination":     pagination(cursor),
+		"total":          len(s.eventSubs),
+		"total_cost":     0,
+		"max_total_cost": eventSubMaxTotalCost,
+	})
+}
@@ -0,0 +1,318 @@
+package faketwitch
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const helixRateLimit = 800
+
+// subTierPoints maps the subscription tiers to the sub points they
+// are worth
+var subTierPoints = map[string]int64{
+	"1000": 1,
+	"2000": 2,
+	"3000": 6,
+}
+
+func (s *Server) registerHelix(r *mux.Router) {
+	r.Use(s.helixMiddleware)
+
+	r.HandleFunc("/channel_points/custom_rewards/redemptions", s.handleHelixUpdateRedemption).Methods(http.MethodPatch)
+	r.HandleFunc("/channels", s.handleHelixChannels).Methods(http.MethodGet)
+	r.HandleFunc("/channels/followers", s.handleHelixFollowers).Methods(http.MethodGet)
+	r.HandleFunc("/chat/shoutouts", s.handleHelixShoutout).Methods(http.MethodPost)
+	r.HandleFunc("/eventsub/subscriptions", s.handleHelixCreateEventSub).Methods(http.MethodPost)
+	r.HandleFunc("/eventsub/subscriptions", s.handleHelixDeleteEventSub).Methods(http.MethodDelete)
+	r.HandleFunc("/eventsub/subscriptions", s.handleHelixListEventSub).Methods(http.MethodGet)
+	r.HandleFunc("/goals", s.handleHelixGoals).Methods(http.MethodGet)
+	r.HandleFunc("/subscriptions", s.handleHelixSubscriptions).Methods(http.MethodGet)
+	r.HandleFunc("/users", s.handleHelixUsers).Methods(http.MethodGet)
+}
+
+// helixMiddleware checks the client-id and token and adds rate-limit
+// headers to all responses
+func (s *Server) helixMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Ratelimit-Limit", strconv.Itoa(helixRateLimit))
+		w.Header().Set("Ratelimit-Remaining", strconv.Itoa(helixRateLimit-1))
+		w.Header().Set("Ratelimit-Reset", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+
+		if err := r.ParseForm(); err != nil {
+			sendError(w, http.StatusBadRequest, "invalid parameters")
+			return
+		}
+
+		if r.Header.Get("Client-Id") != s.ClientID {
+			sendError(w, http.StatusUnauthorized, "Client ID and OAuth token do not match")
+			return
+		}
+
+		if _, ok := s.tokenFromHeader(r, "Bearer"); !ok {
+			sendError(w, http.StatusUnauthorized, "Invalid OAuth token")
+			return
+		}
+
+		s.lock.Lock()
+		path := strings.TrimPrefix(r.URL.Path, "/helix/")
+		s.helixRequests[path] = append(s.helixRequests[path], r.Form)
+		s.lock.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireBroadcaster ensures the request carries a user-access-token
+// of the broadcaster which has the given scope
+func (s *Server) requireBroadcaster(w http.ResponseWriter, r *http.Request, scope string) bool {
+	t, ok := s.tokenFromHeader(r, "Bearer")
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Invalid OAuth token")
+		return false
+	}
+
+	if t.UserID != s.Broadcaster.ID {
+		sendError(w, http.StatusUnauthorized, "User token of the broadcaster required")
+		return false
+	}
+
+	for _, sc := range t.Scopes {
+		if sc == scope {
+			return true
+		}
+	}
+
+	sendError(w, http.StatusUnauthorized, "Missing scope: "+scope)
+	return false
+}
+
+func (s *Server) handleHelixChannels(w http.ResponseWriter, r *http.Request) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var data []map[string]interface{}
+	for _, id := range r.Form["broadcaster_id"] {
+		u, ok := s.users[id]
+		if !ok {
+			continue
+		}
+
+		c := Channel{}
+		if id == s.Broadcaster.ID {
+			c = s.channel
+		}
+
+		data = append(data, map[string]interface{}{
+			"broadcaster_id":       u.ID,
+			"broadcaster_login":    u.Login,
+			"broadcaster_name":     u.DisplayName,
+			"broadcaster_language": c.Language,
+			"game_id":              c.GameID,
+			"game_name":            c.GameName,
+			"title":                c.Title,
+		})
+	}
+
+	sendJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func (s *Server) handleHelixFollowers(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("broadcaster_id") != s.Broadcaster.ID {
+		sendError(w, http.StatusBadRequest, "unknown broadcaster_id")
+		return
+	}
+
+	if !s.requireBroadcaster(w, r, "moderator:read:followers") {
+		return
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	start, end, cursor := paginate(r, len(s.followers))
+
+	data := []map[string]interface{}{}
+	for _, f := range s.followers[start:end] {
+		u := s.users[f.UserID]
+		data = append(data, map[string]interface{}{
+			"user_id":     u.ID,
+			"user_login":  u.Login,
+			"user_name":   u.DisplayName,
+			"followed_at": f.FollowedAt,
+		})
+	}
+
+	sendJSON(w, http.StatusOK, map[string]interface{}{
+		"data":       data,
+		"pagination": pagination(cursor),
+		"total":      len(s.followers),
+	})
+}
+
+func (s *Server) handleHelixGoals(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("broadcaster_id") != s.Broadcaster.ID {
+		sendError(w, http.StatusBadRequest, "unknown broadcaster_id")
+		return
+	}
+
+	if !s.requireBroadcaster(w, r, "channel:read:goals") {
+		return
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	data := []map[string]interface{}{}
+	for _, g := range s.goals {
+		data = append(data, map[string]interface{}{
+			"id":                g.ID,
+			"broadcaster_id":    s.Broadcaster.ID,
+			"broadcaster_login": s.Broadcaster.Login,
+			"broadcaster_name":  s.Broadcaster.DisplayName,
+			"type":              g.Type,
+			"description":       g.Description,
+			"current_amount":    g.CurrentAmount,
+			"target_amount":     g.TargetAmount,
+			"created_at":        g.CreatedAt,
+		})
+	}
+
+	sendJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func (s *Server) handleHelixShoutout(w http.ResponseWriter, r *http.Request) {
+	if !s.requireBroadcaster(w, r, "moderator:manage:shoutouts") {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.users[r.FormValue("to_broadcaster_id")]; !ok {
+		sendError(w, http.StatusBadRequest, "unknown to_broadcaster_id")
+		return
+	}
+
+	s.shoutouts = append(s.shoutouts, r.FormValue("to_broadcaster_id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleHelixSubscriptions(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("broadcaster_id") != s.Broadcaster.ID {
+		sendError(w, http.StatusBadRequest, "unknown broadcaster_id")
+		return
+	}
+
+	if !s.requireBroadcaster(w, r, "channel:read:subscriptions") {
+		return
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var points int64
+	for _, sub := range s.subscriptions {
+		points += subTierPoints[sub.Tier]
+	}
+
+	start, end, cursor := paginate(r, len(s.subscriptions))
+
+	data := []map[string]interface{}{}
+	for _, sub := range s.subscriptions[start:end] {
+		u := s.users[sub.UserID]
+		data = append(data, map[string]interface{}{
+			"broadcaster_id":    s.Broadcaster.ID,
+			"broadcaster_login": s.Broadcaster.Login,
+			"broadcaster_name":  s.Broadcaster.DisplayName,
+			"is_gift":           sub.IsGift,
+			"tier":              sub.Tier,
+			"user_id":           u.ID,
+			"user_login":        u.Login,
+			"user_name":         u.DisplayName,
+		})
+	}
+
+	sendJSON(w, http.StatusOK, map[string]interface{}{
+		"data":       data,
+		"pagination": pagination(cursor),
+		"points":     points,
+		"total":      len(s.subscriptions),
+	})
+}
+
+func (s *Server) handleHelixUpdateRedemption(w http.ResponseWriter, r *http.Request) {
+	if !s.requireBroadcaster(w, r, "channel:manage:redemptions") {
+		return
+	}
+
+	var payload struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		sendError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.redemptionUpdates = append(s.redemptionUpdates, RedemptionUpdate{
+		RewardID:     r.FormValue("reward_id"),
+		RedemptionID: r.FormValue("id"),
+		Status:       payload.Status,
+	})
+
+	sendJSON(w, http.StatusOK, map[string]interface{}{
+		"data": []map[string]interface{}{{
+			"id":     r.FormValue("id"),
+			"status": payload.Status,
+		}},
+	})
+}
+
+func (s *Server) handleHelixUsers(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.tokenFromHeader(r, "Bearer")
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "Invalid OAuth token")
+		return
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	data := []User{}
+	for _, u := range s.users {
+		for _, id := range r.Form["id"] {
+			if u.ID == id {
+				data = append(data, u)
+			}
+		}
+
+		for _, login := range r.Form["login"] {
+			if u.Login == login {
+				data = append(data, u)
+			}
+		}
+	}
+
+	if len(r.Form["id"])+len(r.Form["login"]) == 0 {
+		// Without parameters the user of the token is returned
+		if t.UserID != "" {
+			data = append(data, s.users[t.UserID])
+		}
+	}
+
+	sendJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func pagination(cursor string) map[string]interface{} {
+	if cursor == "" {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{"cursor": cursor}
+}
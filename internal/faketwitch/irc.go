@@ -0,0 +1,275 @@
+package faketwitch
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-irc/irc"
+	"github.com/pkg/errors"
+)
+
+const ircServerName = "tmi.twitch.tv"
+
+type ircServer struct {
+	certPool *x509.CertPool
+	listener net.Listener
+	parent   *Server
+
+	clients  []*irc.Conn
+	messages []string
+	pending  []*irc.Message
+
+	lock sync.Mutex
+}
+
+func newIRCServer(parent *Server) (*ircServer, error) {
+	cert, pool, err := generateCertificate()
+	if err != nil {
+		return nil, errors.Wrap(err, "generating certificate")
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		return nil, errors.Wrap(err, "listening")
+	}
+
+	s := &ircServer{
+		certPool: pool,
+		listener: listener,
+		parent:   parent,
+	}
+
+	go s.accept()
+	return s, nil
+}
+
+// IRCAddr returns the address of the TLS IRC server
+func (s *Server) IRCAddr() string { return s.irc.listener.Addr().String() }
+
+// IRCTLSConfig returns a client configuration trusting the
+// certificate of the IRC server
+func (s *Server) IRCTLSConfig() *tls.Config {
+	return &tls.Config{RootCAs: s.irc.certPool, ServerName: "localhost"}
+}
+
+// ChatMessages returns the texts of all PRIVMSG sent by clients
+func (s *Server) ChatMessages() []string {
+	s.irc.lock.Lock()
+	defer s.irc.lock.Unlock()
+
+	return append([]string(nil), s.irc.messages...)
+}
+
+// SendIRC sends the raw IRC lines (i.e. a PRIVMSG or USERNOTICE with
+// tags) to all clients which joined the channel. Lines are queued
+// until the first client joined.
+func (s *Server) SendIRC(lines ...string) error {
+	var msgs []*irc.Message
+	for _, l := range lines {
+		m, err := irc.ParseMessage(l)
+		if err != nil {
+			return errors.Wrapf(err, "parsing line %q", l)
+		}
+		msgs = append(msgs, m)
+	}
+
+	s.irc.lock.Lock()
+	defer s.irc.lock.Unlock()
+
+	if len(s.irc.clients) == 0 {
+		s.irc.pending = append(s.irc.pending, msgs...)
+		return nil
+	}
+
+	for _, c := range s.irc.clients {
+		for _, m := range msgs {
+			if err := c.WriteMessage(m); err != nil {
+				return errors.Wrap(err, "writing message")
+			}
+		}
+	}
+
+	return nil
+}
+
+func (i *ircServer) Close() error { return i.listener.Close() }
+
+func (i *ircServer) accept() {
+	for {
+		conn, err := i.listener.Accept()
+		if err != nil {
+			// Listener was closed
+			return
+		}
+
+		go i.handle(conn)
+	}
+}
+
+func (i *ircServer) handle(netConn net.Conn) {
+	defer netConn.Close()
+
+	var (
+		c    = irc.NewConn(netConn)
+		nick string
+		pass string
+	)
+
+	defer i.removeClient(c)
+
+	for {
+		m, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		switch m.Command {
+		case "CAP":
+			i.write(c, &irc.Message{
+				Prefix:  &irc.Prefix{Name: ircServerName},
+				Command: "CAP",
+				Params:  []string{"*", "ACK", m.Trailing()},
+			})
+
+		case "JOIN":
+			i.write(c, &irc.Message{
+				Prefix:  &irc.Prefix{Name: nick, User: nick, Host: nick + "." + ircServerName},
+				Command: "JOIN",
+				Params:  m.Params,
+			})
+			i.addClient(c)
+
+		case "NICK":
+			nick = m.Params[0]
+			if !i.authenticate(pass) {
+				i.write(c, &irc.Message{
+					Prefix:  &irc.Prefix{Name: ircServerName},
+					Command: "NOTICE",
+					Params:  []string{"*", "Login authentication failed"},
+				})
+				return
+			}
+
+			i.write(c, &irc.Message{
+				Prefix:  &irc.Prefix{Name: ircServerName},
+				Command: "001",
+				Params:  []string{nick, "Welcome, GLHF!"},
+			})
+
+		case "PASS":
+			pass = m.Params[0]
+
+		case "PING":
+			i.write(c, &irc.Message{
+				Prefix:  &irc.Prefix{Name: ircServerName},
+				Command: "PONG",
+				Params:  m.Params,
+			})
+
+		case "PRIVMSG":
+			i.lock.Lock()
+			i.messages = append(i.messages, m.Trailing())
+			i.lock.Unlock()
+		}
+	}
+}
+
+func (i *ircServer) addClient(c *irc.Conn) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.clients = append(i.clients, c)
+
+	for _, m := range i.pending {
+		c.WriteMessage(m)
+	}
+	i.pending = nil
+}
+
+// write sends the message to the client, serialized with the writes
+// of SendIRC
+func (i *ircServer) write(c *irc.Conn, m *irc.Message) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	c.WriteMessage(m)
+}
+
+func (i *ircServer) removeClient(c *irc.Conn) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	var clients []*irc.Conn
+	for _, client := range i.clients {
+		if client != c {
+			clients = append(clients, client)
+		}
+	}
+	i.clients = clients
+}
+
+// authenticate checks the password sent by the client is a valid
+// user-access-token in the "oauth:<token>" format having the chat scopes
+func (i *ircServer) authenticate(pass string) bool {
+	i.parent.lock.RLock()
+	defer i.parent.lock.RUnlock()
+
+	t, ok := i.parent.tokens[strings.TrimPrefix(pass, "oauth:")]
+	if !ok || t.UserID == "" || time.Now().After(t.ExpiresAt) {
+		return false
+	}
+
+	for _, sc := range t.Scopes {
+		if sc == "chat:read" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// generateCertificate creates a self-signed certificate for localhost
+// and a pool containing it
+func generateCertificate() (tls.Certificate, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, errors.Wrap(err, "generating key")
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, errors.Wrap(err, "creating certificate")
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, errors.Wrap(err, "parsing certificate")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pool, nil
+}
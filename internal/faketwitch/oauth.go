@@ -0,0 +1,133 @@
+package faketwitch
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) registerOAuth(r *mux.Router) {
+	r.HandleFunc("/authorize", s.handleOAuthAuthorize).Methods(http.MethodGet)
+	r.HandleFunc("/token", s.handleOAuthToken).Methods(http.MethodPost)
+	r.HandleFunc("/validate", s.handleOAuthValidate).Methods(http.MethodGet)
+}
+
+// handleOAuthAuthorize grants all requested scopes to the broadcaster
+// without asking and redirects back with a code
+func (s *Server) handleOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("client_id") != s.ClientID {
+		sendError(w, http.StatusBadRequest, "invalid client")
+		return
+	}
+
+	redirect, err := url.Parse(r.FormValue("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		sendError(w, http.StatusBadRequest, "invalid redirect_uri")
+		return
+	}
+
+	code := newID()
+
+	s.lock.Lock()
+	s.codes[code] = strings.Fields(r.FormValue("scope"))
+	s.lock.Unlock()
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("scope", r.FormValue("scope"))
+	params.Set("state", r.FormValue("state"))
+	redirect.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) handleOAuthToken(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("client_id") != s.ClientID || r.FormValue("client_secret") != s.ClientSecret {
+		sendError(w, http.StatusForbidden, "invalid client secret")
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var (
+		accessToken  string
+		refreshToken string
+		scopes       []string
+	)
+
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		var ok bool
+		if scopes, ok = s.codes[r.FormValue("code")]; !ok {
+			sendError(w, http.StatusBadRequest, "Invalid authorization code")
+			return
+		}
+		delete(s.codes, r.FormValue("code"))
+
+		accessToken = s.issueToken(s.Broadcaster.ID, scopes)
+
+	case "client_credentials":
+		accessToken = s.issueToken("", nil)
+
+	case "refresh_token":
+		var ok bool
+		if scopes, ok = s.refreshTokens[r.FormValue("refresh_token")]; !ok {
+			sendError(w, http.StatusBadRequest, "Invalid refresh token")
+			return
+		}
+		delete(s.refreshTokens, r.FormValue("refresh_token"))
+
+		accessToken = s.issueToken(s.Broadcaster.ID, scopes)
+
+	default:
+		sendError(w, http.StatusBadRequest, "unsupported grant_type")
+		return
+	}
+
+	if s.tokens[accessToken].UserID != "" {
+		refreshToken = newID()
+		s.refreshTokens[refreshToken] = scopes
+	}
+
+	resp := map[string]interface{}{
+		"access_token": accessToken,
+		"expires_in":   int64(time.Until(s.tokens[accessToken].ExpiresAt) / time.Second),
+		"token_type":   "bearer",
+	}
+
+	if refreshToken != "" {
+		resp["refresh_token"] = refreshToken
+		resp["scope"] = scopes
+	}
+
+	sendJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleOAuthValidate(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.tokenFromHeader(r, "OAuth")
+	if !ok {
+		sendError(w, http.StatusUnauthorized, "invalid access token")
+		return
+	}
+
+	s.lock.RLock()
+	login := s.users[t.UserID].Login
+	s.lock.RUnlock()
+
+	scopes := t.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	sendJSON(w, http.StatusOK, map[string]interface{}{
+		"client_id":  t.ClientID,
+		"login":      login,
+		"scopes":     scopes,
+		"user_id":    t.UserID,
+		"expires_in": int64(time.Until(t.ExpiresAt) / time.Second),
+	})
+}
@@ -0,0 +1,307 @@
+// Package faketwitch implements a minimal in-process Twitch backend
+// serving the Helix API, the OAuth2 endpoints, EventSub webhook
+// delivery and a TLS IRC server in order to run the twitch-manager
+// against it without a live channel.
+package faketwitch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const defaultPageSize = 20
+
+type (
+	// Server is the fake Twitch backend. All exported methods are safe
+	// for concurrent use.
+	Server struct {
+		ClientID     string
+		ClientSecret string
+		Broadcaster  User
+
+		http *httptest.Server
+		irc  *ircServer
+
+		channel           Channel
+		codes             map[string][]string
+		eventSubs         []*eventSubSubscription
+		followers         []Follower
+		goals             []Goal
+		helixRequests     map[string][]url.Values
+		redemptionUpdates []RedemptionUpdate
+		refreshTokens     map[string][]string
+		shoutouts         []string
+		subscriptions     []Subscription
+		tokens            map[string]*token
+		users             map[string]User
+
+		lock sync.RWMutex
+	}
+
+	// Channel contains the information returned by the channels endpoint
+	Channel struct {
+		GameID   string
+		GameName string
+		Language string
+		Title    string
+	}
+
+	// Follower is a follow of the broadcaster
+	Follower struct {
+		UserID     string
+		FollowedAt time.Time
+	}
+
+	// Goal is an active creator goal of the broadcaster
+	Goal struct {
+		ID            string
+		Type          string
+		Description   string
+		CurrentAmount int64
+		TargetAmount  int64
+		CreatedAt     time.Time
+	}
+
+	// RedemptionUpdate records a status change requested for a
+	// channel-point redemption
+	RedemptionUpdate struct {
+		RewardID     string
+		RedemptionID string
+		Status       string
+	}
+
+	// Subscription is a subscription to the broadcaster
+	Subscription struct {
+		UserID string
+		Tier   string
+		IsGift bool
+	}
+
+	// User is a Twitch user known to the fake backend
+	User struct {
+		ID              string    `json:"id"`
+		Login           string    `json:"login"`
+		DisplayName     string    `json:"display_name"`
+		BroadcasterType string    `json:"broadcaster_type"`
+		ProfileImageURL string    `json:"profile_image_url"`
+		CreatedAt       time.Time `json:"created_at"`
+	}
+
+	token struct {
+		ClientID  string
+		UserID    string
+		Scopes    []string
+		ExpiresAt time.Time
+	}
+)
+
+// New starts a fake backend having the given broadcaster as only user.
+// Close must be called to stop the servers.
+func New(broadcaster User) (*Server, error) {
+	s := &Server{
+		ClientID:     newID(),
+		ClientSecret: newID(),
+		Broadcaster:  broadcaster,
+
+		codes:         make(map[string][]string),
+		helixRequests: make(map[string][]url.Values),
+		refreshTokens: make(map[string][]string),
+		tokens:        make(map[string]*token),
+		users:         map[string]User{broadcaster.ID: broadcaster},
+	}
+
+	router := mux.NewRouter()
+	s.registerHelix(router.PathPrefix("/helix").Subrouter())
+	s.registerOAuth(router.PathPrefix("/oauth2").Subrouter())
+	s.http = httptest.NewServer(router)
+
+	var err error
+	if s.irc, err = newIRCServer(s); err != nil {
+		s.http.Close()
+		return nil, errors.Wrap(err, "starting IRC server")
+	}
+
+	return s, nil
+}
+
+// Close stops all servers
+func (s *Server) Close() {
+	s.http.Close()
+	s.irc.Close()
+}
+
+// APIBaseURL returns the URL to use as Helix base
+func (s *Server) APIBaseURL() string { return s.http.URL + "/helix" }
+
+// IDBaseURL returns the URL to use as OAuth2 base
+func (s *Server) IDBaseURL() string { return s.http.URL + "/oauth2" }
+
+// AddUser makes the user known to the users endpoint
+func (s *Server) AddUser(u User) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.users[u.ID] = u
+}
+
+// AddFollower lets the known user follow the broadcaster
+func (s *Server) AddFollower(userID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return errors.Errorf("unknown user %s", userID)
+	}
+
+	// Followers are listed newest first
+	s.followers = append([]Follower{{UserID: userID, FollowedAt: time.Now()}}, s.followers...)
+	return nil
+}
+
+// AddSubscription lets the known user subscribe to the broadcaster
+func (s *Server) AddSubscription(sub Subscription) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.users[sub.UserID]; !ok {
+		return errors.Errorf("unknown user %s", sub.UserID)
+	}
+
+	s.subscriptions = append(s.subscriptions, sub)
+	return nil
+}
+
+// SetGoals replaces the active creator goals
+func (s *Server) SetGoals(goals ...Goal) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.goals = goals
+}
+
+// SetChannel sets the information returned for the broadcasters channel
+func (s *Server) SetChannel(c Channel) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.channel = c
+}
+
+// IssueUserToken creates a user-access-token for the broadcaster
+// having the given scopes
+func (s *Server) IssueUserToken(scopes ...string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.issueToken(s.Broadcaster.ID, scopes)
+}
+
+// ExpireToken invalidates the given access-token
+func (s *Server) ExpireToken(accessToken string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.tokens, accessToken)
+}
+
+// HelixRequests returns the parameters of all authorized requests made
+// to the given Helix path (i.e. "users")
+func (s *Server) HelixRequests(path string) []url.Values {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return append([]url.Values(nil), s.helixRequests[path]...)
+}
+
+// RedemptionUpdates returns all redemption status changes requested
+func (s *Server) RedemptionUpdates() []RedemptionUpdate {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return append([]RedemptionUpdate(nil), s.redemptionUpdates...)
+}
+
+// Shoutouts returns the IDs of all channels which got a shoutout
+func (s *Server) Shoutouts() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return append([]string(nil), s.shoutouts...)
+}
+
+// issueToken creates a new access-token. An empty userID creates an
+// app-access-token. Must be called while holding the lock.
+func (s *Server) issueToken(userID string, scopes []string) string {
+	t := newID()
+	s.tokens[t] = &token{
+		ClientID:  s.ClientID,
+		UserID:    userID,
+		Scopes:    scopes,
+		ExpiresAt: time.Now().Add(4 * time.Hour),
+	}
+	return t
+}
+
+// tokenFromHeader checks the Authorization header against the issued
+// tokens using the given prefix ("Bearer" or "OAuth")
+func (s *Server) tokenFromHeader(r *http.Request, prefix string) (*token, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix+" ") {
+		return nil, false
+	}
+
+	t, ok := s.tokens[strings.TrimPrefix(auth, prefix+" ")]
+	if !ok || time.Now().After(t.ExpiresAt) {
+		return nil, false
+	}
+
+	return t, true
+}
+
+func newID() string { return uuid.Must(uuid.NewV4()).String() }
+
+// paginate returns the bounds of the requested page for a list of the
+// given length and the cursor to the next page
+func paginate(r *http.Request, length int) (start, end int, cursor string) {
+	first, err := strconv.Atoi(r.FormValue("first"))
+	if err != nil || first < 1 || first > 100 {
+		first = defaultPageSize
+	}
+
+	if start, err = strconv.Atoi(r.FormValue("after")); err != nil || start < 0 || start > length {
+		start = 0
+	}
+
+	if end = start + first; end >= length {
+		return start, length, ""
+	}
+
+	return start, end, strconv.Itoa(end)
+}
+
+func sendError(w http.ResponseWriter, status int, message string) {
+	sendJSON(w, status, map[string]interface{}{
+		"error":   http.StatusText(status),
+		"status":  status,
+		"message": message,
+	})
+}
+
+func sendJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
@@ -3,19 +3,33 @@ package main
 import (
 	"context"
 	"crypto/tls"
-	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-irc/irc"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
-var regexpHostNotification = regexp.MustCompile(`^(?P<actor>\w+) is now(?: auto)? hosting you(?: for (?P<amount>[0-9]+) viewers)?.$`)
+const (
+	ircChatQueueSize  = 10
+	ircReconnectDelay = 30 * time.Second
+)
+
+var (
+	ircChatMessages = make(chan string, ircChatQueueSize)
+
+	// ircTLSConfig is used to connect to the IRC server, nil uses the
+	// system defaults
+	ircTLSConfig *tls.Config
+
+	regexpHostNotification = regexp.MustCompile(`^(?P<actor>\w+) is now(?: auto)? hosting you(?: for (?P<amount>[0-9]+) viewers)?.$`)
+)
 
 type ircHandler struct {
 	conn *tls.Conn
@@ -31,14 +45,19 @@ func newIRCHandler() (*ircHandler, error) {
 		return nil, errors.Wrap(err, "fetching username")
 	}
 
-	conn, err := tls.Dial("tcp", "irc.chat.twitch.tv:6697", nil)
+	token, err := userAccessTokens.Get(context.Background())
+	if err != nil {
+		return nil, errors.Wrap(err, "getting user-access-token")
+	}
+
+	conn, err := tls.Dial("tcp", cfg.TwitchIRCServer, ircTLSConfig)
 	if err != nil {
 		return nil, errors.Wrap(err, "connect to IRC server")
 	}
 
 	h.c = irc.NewClient(conn, irc.ClientConfig{
 		Nick:    username,
-		Pass:    strings.Join([]string{"oauth", cfg.TwitchToken}, ":"),
+		Pass:    strings.Join([]string{"oauth", token}, ":"),
 		User:    username,
 		Name:    username,
 		Handler: h,
@@ -68,6 +87,11 @@ func (i ircHandler) Handle(c *irc.Client, m *irc.Message) {
 		})
 		c.Write(fmt.Sprintf("JOIN #%s", i.user))
 
+	case "CLEARCHAT":
+		// CLEARCHAT (Twitch Commands)
+		// Purges all chat messages in a channel, or purges chat messages from a specific user
+		i.handleTwitchClearchat(m)
+
 	case "NOTICE":
 		// NOTICE (Twitch Commands)
 		// General notices from the server.
@@ -96,26 +120,16 @@ func (i ircHandler) Handle(c *irc.Client, m *irc.Message) {
 	}
 }
 
+func (i ircHandler) SendMessage(text string) error {
+	return errors.Wrap(i.c.WriteMessage(&irc.Message{
+		Command: "PRIVMSG",
+		Params:  []string{"#" + i.user, text},
+	}), "sending message")
+}
+
 func (i ircHandler) Run() error { return errors.Wrap(i.c.Run(), "running IRC client") }
 
 func (ircHandler) fetchTwitchUsername() (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), twitchRequestTimeout)
-	defer cancel()
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://api.twitch.tv/helix/users?id=%s", cfg.TwitchID), nil)
-	if err != nil {
-		return "", errors.Wrap(err, "assemble user request")
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Client-Id", cfg.TwitchClient)
-	req.Header.Set("Authorization", "Bearer "+cfg.TwitchToken)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", errors.Wrap(err, "requesting user info")
-	}
-	defer resp.Body.Close()
-
 	var payload struct {
 		Data []struct {
 			ID    string `json:"id"`
@@ -123,8 +137,13 @@ func (ircHandler) fetchTwitchUsername() (string, error) {
 		} `json:"data"`
 	}
 
-	if err = json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		return "", errors.Wrap(err, "parse user info")
+	if err := helix.Do(context.Background(), helixRequest{
+		Method: http.MethodGet,
+		Path:   "users",
+		Params: url.Values{"id": []string{cfg.TwitchID}},
+		Auth:   helixAuthUser,
+	}, &payload); err != nil {
+		return "", errors.Wrap(err, "requesting user info")
 	}
 
 	if l := len(payload.Data); l != 1 {
@@ -138,6 +157,34 @@ func (ircHandler) fetchTwitchUsername() (string, error) {
 	return payload.Data[0].Login, nil
 }
 
+func (ircHandler) handleTwitchClearchat(m *irc.Message) {
+	log.WithFields(log.Fields{
+		"tags":     m.Tags,
+		"trailing": m.Trailing(),
+	}).Debug("IRC CLEARCHAT event")
+
+	if len(m.Params) < 2 {
+		// No target user: the whole chat was cleared
+		return
+	}
+
+	if _, ok := m.Tags["ban-duration"]; ok {
+		// User got timed out, not banned
+		return
+	}
+
+	purgeBannedUser(string(m.Tags["target-user-id"]), m.Trailing())
+
+	// Execute store save
+	if err := store.Save(cfg.StoreFile); err != nil {
+		log.WithError(err).Error("Unable to update persistent store")
+	}
+
+	if err := store.WithModRLock(func() error { return subscriptions.SendAllSockets(msgTypeStore, store, false, false) }); err != nil {
+		log.WithError(err).Error("Unable to send update to all sockets")
+	}
+}
+
 func (ircHandler) handleTwitchNotice(m *irc.Message) {
 	log.WithFields(log.Fields{
 		"tags":     m.Tags,
@@ -173,10 +220,13 @@ func (ircHandler) handleTwitchPrivmsg(m *irc.Message) {
 			matches[2] = "0"
 		}
 
-		subscriptions.SendAllSockets(msgTypeHost, map[string]interface{}{
+		fields := map[string]interface{}{
 			"from":        matches[1],
 			"viewerCount": matches[2],
-		}, false, true)
+		}
+		userProfiles.Enrich(fields, "", matches[1])
+
+		subscriptions.SendAllSockets(msgTypeHost, fields, false, true)
 	}
 
 	// Handle bit-messages
@@ -191,31 +241,11 @@ func (ircHandler) handleTwitchPrivmsg(m *irc.Message) {
 		if !ok {
 			displayName = irc.TagValue(m.User)
 		}
-		strDisplayName := string(displayName)
 
-		fields := map[string]interface{}{
-			"from":    displayName,
-			"amount":  bitAmount,
-			"message": m.Trailing(),
+		if !bitDonations.Process(bitDonationSourceIRC, string(m.Tags["user-id"]), m.User, string(displayName), bitAmount, m.Trailing()) {
+			return
 		}
 
-		store.WithModLock(func() error {
-			store.BitDonations.LastDonator = &strDisplayName
-			store.BitDonations.LastAmount = bitAmount
-			if store.BitDonations.TotalAmounts == nil {
-				store.BitDonations.TotalAmounts = map[string]int64{}
-			}
-			store.BitDonations.TotalAmounts[m.User] += bitAmount
-
-			fields["total_amount"] = store.BitDonations.TotalAmounts[m.User]
-
-			return nil
-		})
-
-		// Send update to sockets
-		log.WithFields(log.Fields(fields)).Info("Bit donation")
-		subscriptions.SendAllSockets(msgTypeBits, fields, false, true)
-
 		// Execute store save
 		if err := store.Save(cfg.StoreFile); err != nil {
 			log.WithError(err).Error("Unable to update persistent store")
@@ -233,118 +263,31 @@ func (ircHandler) handleTwitchUsernotice(m *irc.Message) {
 		"trailing": m.Trailing,
 	}).Debug("IRC USERNOTICE event")
 
-	displayName, ok := m.Tags["msg-param-displayName"]
-	if !ok {
-		displayName = m.Tags["login"]
-	}
-
 	switch m.Tags["msg-id"] {
 	case "":
 		// Notices SHOULD have msg-id tags...
 		log.WithField("msg", m).Warn("Received usernotice without msg-id")
 
 	case "raid":
-		fields := map[string]interface{}{
-			"from":        displayName,
-			"viewerCount": m.Tags["msg-param-viewerCount"],
-		}
-
-		log.WithFields(log.Fields(fields)).Info("Incoming raid")
-		subscriptions.SendAllSockets(msgTypeRaid, fields, false, true)
-
-	case "sub", "resub":
-		fields := map[string]interface{}{
-			"from":     displayName,
-			"is_resub": m.Tags["msg-id"] == "resub",
-			"message":  m.Trailing(),
-			"paid_for": m.Tags["msg-param-multimonth-duration"],
-			"streak":   m.Tags["msg-param-streak-months"],
-			"tier":     m.Tags["msg-param-sub-plan"],
-			"total":    m.Tags["msg-param-cumulative-months"],
-		}
+		// Raids are handled through EventSub (channel.raid) which also
+		// provides the viewer count as a number
+		log.WithField("msg-id", m.Tags["msg-id"]).Debug("Skipping raid usernotice, handled by EventSub")
 
-		if fields["message"] == m.Params[0] {
-			// Empty resub-messages will cause the message to be the channel name
-			delete(fields, "message")
-		}
-
-		// Update store
-		strDisplayName := string(displayName)
-		var duration int64
-		if v, err := strconv.ParseInt(string(m.Tags["msg-param-cumulative-months"]), 10, 64); err == nil {
-			duration = v
-		}
-
-		store.WithModLock(func() error {
-			store.Subs.Last = &strDisplayName
-			store.Subs.LastDuration = duration
-			store.Subs.Recent = append([]subscriber{{
-				Name:   strDisplayName,
-				Months: duration,
-			}}, store.Subs.Recent...)
-
-			return nil
-		})
+	case "sub", "resub", "subgift", "anonsubgift":
+		// Subscriptions are handled through EventSub (channel.subscribe,
+		// channel.subscription.message, channel.subscription.gift) as
+		// those are retried by Twitch when we miss them
+		log.WithField("msg-id", m.Tags["msg-id"]).Debug("Skipping subscription usernotice, handled by EventSub")
 
-		// Send update to sockets
-		log.WithFields(log.Fields(fields)).Info("New subscriber")
-		subscriptions.SendAllSockets(msgTypeSub, fields, false, true)
-
-		// Execute store save
-		if err := store.Save(cfg.StoreFile); err != nil {
-			log.WithError(err).Error("Unable to update persistent store")
-		}
-
-		if err := store.WithModRLock(func() error { return subscriptions.SendAllSockets(msgTypeStore, store, false, false) }); err != nil {
-			log.WithError(err).Error("Unable to send update to all sockets")
-		}
-
-	case "subgift", "anonsubgift":
-		toName, ok := m.Tags["msg-param-recipient-display-name"]
-		if !ok {
-			toName = m.Tags["msg-param-recipient-user-name"]
-		}
-
-		fields := map[string]interface{}{
-			"from":     displayName,
-			"is_anon":  m.Tags["msg-id"] == "anonsubgift",
-			"gift_to":  toName,
-			"paid_for": m.Tags["msg-param-gift-months"],
-			"streak":   m.Tags["msg-param-streak-months"],
-			"tier":     m.Tags["msg-param-sub-plan"],
-			"total":    m.Tags["msg-param-months"],
-		}
-
-		// Update store
-		strDisplayName := string(toName)
-		var duration int64
-		if v, err := strconv.ParseInt(string(m.Tags["msg-param-months"]), 10, 64); err == nil {
-			duration = v
-		}
-
-		store.WithModLock(func() error {
-			store.Subs.Last = &strDisplayName
-			store.Subs.LastDuration = duration
-			store.Subs.Recent = append([]subscriber{{
-				Name:   strDisplayName,
-				Months: duration,
-			}}, store.Subs.Recent...)
-
-			return nil
-		})
-
-		// Send update to sockets
-		log.WithFields(log.Fields(fields)).Info("New sub-gift")
-		subscriptions.SendAllSockets(msgTypeSubGift, fields, false, true)
-
-		// Execute store save
-		if err := store.Save(cfg.StoreFile); err != nil {
-			log.WithError(err).Error("Unable to update persistent store")
-		}
-
-		if err := store.WithModRLock(func() error { return subscriptions.SendAllSockets(msgTypeStore, store, false, false) }); err != nil {
-			log.WithError(err).Error("Unable to send update to all sockets")
-		}
+	}
+}
 
+// sendChatMessage queues a message to be posted into the channel chat
+// by the currently active IRC connection
+func sendChatMessage(text string) {
+	select {
+	case ircChatMessages <- text:
+	default:
+		log.WithField("message", text).Warn("Chat message queue is full, dropping message")
 	}
 }
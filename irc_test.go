@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Luzifer/go_helpers/v2/str"
+
+	"github.com/Luzifer/twitch-manager/internal/faketwitch"
+)
+
+func TestIRCBitsAndChat(t *testing.T) {
+	env := newTestEnv(t, "chat:read", "chat:edit")
+
+	env.fake.AddUser(faketwitch.User{ID: "2000", Login: "cheerer", DisplayName: "Cheerer"})
+
+	h, err := newIRCHandler()
+	if err != nil {
+		t.Fatalf("creating IRC handler: %s", err)
+	}
+	defer h.Close()
+
+	go h.Run()
+
+	if err = env.fake.SendIRC(
+		"@bits=100;display-name=Cheerer;user-id=2000 :cheerer!cheerer@cheerer.tmi.twitch.tv PRIVMSG #broadcaster :cheer100 Have some bits!",
+	); err != nil {
+		t.Fatalf("sending cheer: %s", err)
+	}
+
+	waitFor(t, "bits message", func() bool { return len(env.socketMessages(msgTypeBits)) > 0 })
+
+	fields := env.socketMessages(msgTypeBits)[0].Payload.(map[string]interface{})
+	if fields["amount"] != int64(100) {
+		t.Errorf("expected 100 bits, got %v", fields["amount"])
+	}
+	if fields["from_id"] != "2000" {
+		t.Errorf("expected cheer from user 2000, got %v", fields["from_id"])
+	}
+
+	store.WithModRLock(func() error {
+		if a := store.BitDonations.TotalAmounts["cheerer"]; a != 100 {
+			t.Errorf("expected total of 100 bits, got %d", a)
+		}
+		return nil
+	})
+
+	// The same cheer reported through EventSub must not alert again
+	if bitDonations.Process(bitDonationSourceEventSub, "2000", "cheerer", "Cheerer", 100, "Have some bits!") {
+		t.Error("expected EventSub cheer to be deduplicated")
+	}
+
+	if err = h.SendMessage("Thanks for the bits!"); err != nil {
+		t.Fatalf("sending chat message: %s", err)
+	}
+
+	waitFor(t, "chat message", func() bool {
+		return str.StringInSlice("Thanks for the bits!", env.fake.ChatMessages())
+	})
+}
@@ -7,7 +7,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/gofrs/uuid"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 
@@ -16,28 +15,43 @@ import (
 
 var (
 	cfg = struct {
-		AssetCheckInterval    time.Duration `flag:"asset-check-interval" default:"1m" description:"How often to check asset files for updates"`
-		AssetDir              string        `flag:"asset-dir" default:"./public" description:"Directory containing assets"`
-		BaseURL               string        `flag:"base-url" default:"" description:"Base URL of this service" validate:"nonzero"`
-		ForceSyncInterval     time.Duration `flag:"force-sync-interval" default:"1m" description:"How often to force a sync without updates"`
-		Listen                string        `flag:"listen" default:":3000" description:"Port/IP to listen on"`
-		LogLevel              string        `flag:"log-level" default:"info" description:"Log level (debug, info, warn, error, fatal)"`
-		StoreFile             string        `flag:"store-file" default:"store.json.gz" description:"File to store the state to"`
-		TwitchClient          string        `flag:"twitch-client" default:"" description:"Client ID to act as" validate:"nonzero"`
-		TwitchSecret          string        `flag:"twitch-secret" default:"" description:"Secret to the given Client ID" validate:"nonzero"`
-		TwitchID              string        `flag:"twitch-id" default:"" description:"ID of the user of the overlay" validate:"nonzero"`
-		TwitchToken           string        `flag:"twitch-token" default:"" description:"OAuth token valid for client"`
-		UpdateFromAPIInterval time.Duration `flag:"update-from-api-interval" default:"10m" description:"How often to ask the API for real values"`
-		VersionAndExit        bool          `flag:"version" default:"false" description:"Prints current version and exits"`
-		WebHookSecret         string        `flag:"webhook-secret" default:"" description:"Secret to use for HMAC hashing of webhook payload"`
+		AssetCheckInterval        time.Duration `flag:"asset-check-interval" default:"1m" description:"How often to check asset files for updates"`
+		AssetDir                  string        `flag:"asset-dir" default:"./public" description:"Directory containing assets"`
+		BaseURL                   string        `flag:"base-url" default:"" description:"Base URL of this service (required for webhook transport)"`
+		EventSubReconcileInterval time.Duration `flag:"eventsub-reconcile-interval" default:"15m" description:"How often to check EventSub subscriptions for stale or missing entries"`
+		EventSubTransport         string        `flag:"eventsub-transport" default:"webhook" description:"How to receive EventSub notifications (webhook, websocket)"`
+		EventSubWebsocketURL      string        `flag:"eventsub-websocket-url" default:"wss://eventsub.wss.twitch.tv/ws" description:"URL of the EventSub WebSocket endpoint"`
+		FollowerFullSync          bool          `flag:"follower-full-sync" default:"false" description:"Fetch all followers on startup to prevent alerts for re-follows"`
+		ForceSyncInterval         time.Duration `flag:"force-sync-interval" default:"1m" description:"How often to force a sync without updates"`
+		Listen                    string        `flag:"listen" default:":3000" description:"Port/IP to listen on"`
+		LogLevel                  string        `flag:"log-level" default:"info" description:"Log level (debug, info, warn, error, fatal)"`
+		RaidChatMessage           string        `flag:"raid-chat-message" default:"" description:"Chat message to post on incoming raids (Go template, fields: from, viewerCount, game, title)"`
+		RaidShoutout              bool          `flag:"raid-shoutout" default:"false" description:"Send a shoutout for incoming raids"`
+		StoreFile                 string        `flag:"store-file" default:"store.json.gz" description:"File to store the state to"`
+		TokenValidateInterval     time.Duration `flag:"token-validate-interval" default:"1h" description:"How often to validate the user-access-token"`
+		TwitchAPIBaseURL          string        `flag:"twitch-api-base-url" default:"https://api.twitch.tv/helix" description:"Base URL of the Twitch Helix API"`
+		TwitchClient              string        `flag:"twitch-client" default:"" description:"Client ID to act as" validate:"nonzero"`
+		TwitchSecret              string        `flag:"twitch-secret" default:"" description:"Secret to the given Client ID" validate:"nonzero"`
+		TwitchID                  string        `flag:"twitch-id" default:"" description:"ID of the user of the overlay" validate:"nonzero"`
+		TwitchIDBaseURL           string        `flag:"twitch-id-base-url" default:"https://id.twitch.tv/oauth2" description:"Base URL of the Twitch OAuth2 API"`
+		TwitchIRCServer           string        `flag:"twitch-irc-server" default:"irc.chat.twitch.tv:6697" description:"Address of the Twitch IRC server (TLS)"`
+		TwitchToken               string        `flag:"twitch-token" default:"" description:"OAuth token valid for client"`
+		UpdateFromAPIInterval     time.Duration `flag:"update-from-api-interval" default:"10m" description:"How often to ask the API for real values"`
+		VersionAndExit            bool          `flag:"version" default:"false" description:"Prints current version and exits"`
+		WebHookSecret             string        `flag:"webhook-secret" default:"" description:"Secret to use for HMAC hashing of webhook payload"`
 	}{}
 
 	store *storage
 
+	webhookSecretChanged bool
+
 	version = "dev"
 )
 
-func init() {
+// initApp parses the configuration and prepares the global state. It
+// is not executed as init function in order not to parse the flags of
+// the test binary.
+func initApp() {
 	rconfig.AutoEnv(true)
 	if err := rconfig.ParseAndValidate(&cfg); err != nil {
 		log.Fatalf("Unable to parse commandline options: %s", err)
@@ -54,12 +68,34 @@ func init() {
 		log.SetLevel(l)
 	}
 
-	if cfg.WebHookSecret == "" {
-		cfg.WebHookSecret = uuid.Must(uuid.NewV4()).String()
+	helix = newHelixClient(cfg.TwitchAPIBaseURL, cfg.TwitchIDBaseURL, cfg.TwitchClient)
+
+	switch cfg.EventSubTransport {
+	case "webhook":
+		if cfg.BaseURL == "" {
+			log.Fatal("Base URL is required for webhook transport")
+		}
+
+	case "websocket":
+		// No further requirements
+
+	default:
+		log.Fatalf("Unknown EventSub transport %q", cfg.EventSubTransport)
+	}
+
+	if err := parseRaidChatTemplate(); err != nil {
+		log.WithError(err).Fatal("Invalid raid chat message")
+	}
+
+	var err error
+	if webhookSecretChanged, err = loadWebhookSecret(); err != nil {
+		log.WithError(err).Fatal("Unable to load webhook secret")
 	}
 }
 
 func main() {
+	initApp()
+
 	var err error
 
 	store = newStorage()
@@ -67,6 +103,22 @@ func main() {
 		log.WithError(err).Fatal("Unable to load store")
 	}
 
+	if err = knownFollowers.Load(); err != nil && !os.IsNotExist(err) {
+		log.WithError(err).Fatal("Unable to load known followers")
+	}
+
+	switch err = userAccessTokens.Load(); {
+	case err == nil:
+		log.Info("Using stored user-access-token")
+
+	case !os.IsNotExist(err):
+		log.WithError(err).Error("Unable to load stored user-access-token")
+	}
+
+	if err = checkUserToken(); err != nil {
+		log.WithError(err).Error("User-access-token is not usable")
+	}
+
 	if err = assetVersions.UpdateAssetHashes(cfg.AssetDir); err != nil {
 		log.WithError(err).Fatal("Unable to read asset hashes")
 	}
@@ -94,8 +146,29 @@ func main() {
 		}
 	}()
 
-	if err = registerEventSubHooks(); err != nil {
-		log.WithError(err).Fatal("Unable to register webhooks")
+	switch cfg.EventSubTransport {
+	case "webhook":
+		if webhookSecretChanged {
+			log.Warn("Webhook secret changed, re-creating EventSub subscriptions")
+			if err = removeEventSubWebhookSubscriptions(); err != nil {
+				log.WithError(err).Fatal("Unable to remove outdated webhooks")
+			}
+		}
+
+		switch err = registerEventSubHooks(); {
+		case err == nil:
+			// All subscriptions are active
+
+		case isEventSubRegistrationError(err):
+			// Failed types are shown in the status and retried by the reconciliation
+			log.WithError(err).Error("Unable to register some webhooks")
+
+		default:
+			log.WithError(err).Fatal("Unable to register webhooks")
+		}
+
+	case "websocket":
+		go runEventSubSocket(cfg.EventSubWebsocketURL)
 	}
 
 	var (
@@ -103,21 +176,57 @@ func main() {
 		ircDisconnected = make(chan struct{}, 1)
 
 		timerAssetCheck    = time.NewTicker(cfg.AssetCheckInterval)
+		timerEventSub      = time.NewTicker(cfg.EventSubReconcileInterval)
 		timerForceSync     = time.NewTicker(cfg.ForceSyncInterval)
+		timerTokenValidate = time.NewTicker(cfg.TokenValidateInterval)
 		timerUpdateFromAPI = time.NewTicker(cfg.UpdateFromAPIInterval)
 	)
 
 	ircDisconnected <- struct{}{}
 
+	go shoutouts.Run()
+	go userProfiles.Run()
+
+	go func() {
+		if err := updateChannelInfo(); err != nil {
+			log.WithError(err).Error("Unable to fetch channel info")
+		}
+
+		if err := updateCreatorGoals(); err != nil {
+			log.WithError(err).Error("Unable to fetch creator goals")
+		}
+	}()
+
+	if cfg.FollowerFullSync {
+		go func() {
+			if err := syncAllFollowers(); err != nil {
+				log.WithError(err).Error("Unable to sync all followers")
+			}
+		}()
+	}
+
 	for {
 		select {
+		case msg := <-ircChatMessages:
+			if irc == nil {
+				log.Warn("No IRC connection to send chat message")
+				continue
+			}
+
+			if err := irc.SendMessage(msg); err != nil {
+				log.WithError(err).Error("Unable to send chat message")
+			}
+
 		case <-ircDisconnected:
 			if irc != nil {
 				irc.Close()
 			}
 
 			if irc, err = newIRCHandler(); err != nil {
-				log.WithError(err).Fatal("Unable to create IRC client")
+				log.WithError(err).Error("Unable to create IRC client")
+				irc = nil
+				time.AfterFunc(ircReconnectDelay, func() { ircDisconnected <- struct{}{} })
+				continue
 			}
 
 			go func() {
@@ -128,16 +237,34 @@ func main() {
 				ircDisconnected <- struct{}{}
 			}()
 
+		case <-userTokenChanged:
+			// Reconnect IRC to log in using the new token
+			if irc != nil {
+				irc.Close()
+			}
+
 		case <-timerAssetCheck.C:
 			if err := assetVersions.UpdateAssetHashes(cfg.AssetDir); err != nil {
 				log.WithError(err).Error("Unable to update asset hashes")
 			}
 
+		case <-timerEventSub.C:
+			go func() {
+				if err := reconcileEventSubSubscriptions(); err != nil {
+					log.WithError(err).Error("Unable to reconcile EventSub subscriptions")
+				}
+			}()
+
 		case <-timerForceSync.C:
 			if err := subscriptions.SendAllSockets(msgTypeStore, store, false, false); err != nil {
 				log.WithError(err).Error("Unable to send store to all sockets")
 			}
 
+		case <-timerTokenValidate.C:
+			if err := checkUserToken(); err != nil {
+				log.WithError(err).Error("User-access-token is not usable")
+			}
+
 		case <-timerUpdateFromAPI.C:
 			if err := updateStats(); err != nil {
 				log.WithError(err).Error("Unable to update statistics from API")
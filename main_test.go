@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Luzifer/twitch-manager/internal/faketwitch"
+)
+
+const testWaitTimeout = 5 * time.Second
+
+var testBroadcaster = faketwitch.User{
+	ID:          "1000",
+	Login:       "broadcaster",
+	DisplayName: "Broadcaster",
+}
+
+// testEnv wires the service against a fake Twitch backend and records
+// all messages sent to the sockets
+type testEnv struct {
+	fake *faketwitch.Server
+	api  *httptest.Server
+
+	messages []socketMessage
+	lock     sync.Mutex
+}
+
+// newTestEnv resets the global state of the service and points it to
+// a freshly started fake backend. The user-access-token carries the
+// given scopes.
+func newTestEnv(t *testing.T, scopes ...string) *testEnv {
+	t.Helper()
+
+	fake, err := faketwitch.New(testBroadcaster)
+	if err != nil {
+		t.Fatalf("starting fake backend: %s", err)
+	}
+	t.Cleanup(fake.Close)
+
+	router := mux.NewRouter()
+	registerAPI(router)
+	api := httptest.NewServer(router)
+	t.Cleanup(api.Close)
+
+	cfg.BaseURL = api.URL
+	cfg.StoreFile = path.Join(t.TempDir(), "store.json.gz")
+	cfg.TwitchClient = fake.ClientID
+	cfg.TwitchSecret = fake.ClientSecret
+	cfg.TwitchID = testBroadcaster.ID
+	cfg.TwitchIRCServer = fake.IRCAddr()
+	cfg.TwitchToken = fake.IssueUserToken(scopes...)
+	cfg.WebHookSecret = "testsecret"
+
+	appAccessTokens = new(appAccessTokenManager)
+	bitDonations = newBitDonationHandler()
+	eventSubStatus = newEventSubStatusStore()
+	helix = newHelixClient(fake.APIBaseURL(), fake.IDBaseURL(), fake.ClientID)
+	ircTLSConfig = fake.IRCTLSConfig()
+	knownFollowers = newFollowerSet()
+	store = newStorage()
+	userAccessTokens = new(userAccessTokenManager)
+
+	userProfiles = newUserProfileService()
+	go userProfiles.Run()
+
+	env := &testEnv{fake: fake, api: api}
+
+	subscriptions.SubscribeSocket(t.Name(), func(msg socketMessage) error {
+		env.lock.Lock()
+		defer env.lock.Unlock()
+
+		env.messages = append(env.messages, msg)
+		return nil
+	})
+	t.Cleanup(func() { subscriptions.UnsubscribeSocket(t.Name()) })
+
+	return env
+}
+
+// socketMessages returns all messages of the given type sent to the
+// sockets so far
+func (e *testEnv) socketMessages(msgType string) []socketMessage {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	var out []socketMessage
+	for _, msg := range e.messages {
+		if msg.Type == msgType {
+			out = append(out, msg)
+		}
+	}
+	return out
+}
+
+// waitFor polls the condition until it is met or fails the test after
+// testWaitTimeout
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testWaitTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// storedEventUserFields contains the payload fields of stored events
+	// which might reference a user by name
+	storedEventUserFields = []string{"from", "from_login", "gift_to", "name"}
+	// storedEventUserIDFields contains the payload fields of stored
+	// events which might reference a user by ID
+	storedEventUserIDFields = []string{"from_id"}
+)
+
+// purgeBannedUser removes all traces of the user from the store so
+// banned users (i.e. follow-bots) do not show up in recent lists or
+// replays. The user is matched by ID (if known) and case-insensitive
+// against all given names (login, display name).
+func purgeBannedUser(userID string, names ...string) {
+	matches := func(v string) bool {
+		for _, n := range names {
+			if n != "" && strings.EqualFold(n, v) {
+				return true
+			}
+		}
+		return false
+	}
+
+	matchesID := func(v string) bool { return userID != "" && v == userID }
+
+	store.WithModLock(func() error {
+		var seen []string
+		for _, f := range store.Followers.Seen {
+			if !matches(f) {
+				seen = append(seen, f)
+			}
+		}
+		store.Followers.Seen = seen
+
+		if store.Followers.Last != nil && matches(*store.Followers.Last) {
+			store.Followers.Last = nil
+		}
+
+		var recent []subscriber
+		for _, s := range store.Subs.Recent {
+			if !matchesID(s.UserID) && !matches(s.Login) && !matches(s.Name) {
+				recent = append(recent, s)
+				continue
+			}
+
+			// Display names might differ from the login (i.e. localized
+			// names) so the last subscriber is also matched through the
+			// purged entries
+			if store.Subs.Last != nil && *store.Subs.Last == s.Name {
+				store.Subs.Last = nil
+			}
+		}
+		store.Subs.Recent = recent
+
+		if store.Subs.Last != nil && matches(*store.Subs.Last) {
+			store.Subs.Last = nil
+		}
+
+		for name := range store.BitDonations.TotalAmounts {
+			if matches(name) {
+				delete(store.BitDonations.TotalAmounts, name)
+			}
+		}
+
+		if store.BitDonations.LastDonator != nil && matches(*store.BitDonations.LastDonator) {
+			store.BitDonations.LastDonator = nil
+		}
+
+		var events []storedEvent
+		for _, evt := range store.Events {
+			var payload map[string]interface{}
+			if err := json.Unmarshal(evt.Message, &payload); err != nil {
+				// Not an object, cannot reference a user
+				events = append(events, evt)
+				continue
+			}
+
+			var isMatch bool
+			for _, field := range storedEventUserFields {
+				if v, ok := payload[field].(string); ok && matches(v) {
+					isMatch = true
+				}
+			}
+
+			for _, field := range storedEventUserIDFields {
+				if v, ok := payload[field].(string); ok && matchesID(v) {
+					isMatch = true
+				}
+			}
+
+			if !isMatch {
+				events = append(events, evt)
+			}
+		}
+		store.Events = events
+
+		return nil
+	})
+
+	log.WithFields(log.Fields{
+		"id":    userID,
+		"names": names,
+	}).Info("Purged banned user from store")
+}
@@ -15,12 +15,32 @@ const app = new Vue({
       return icons
     },
 
-    nextFollowers() {
-      return Math.ceil((this.store.followers.count + 1) / 25) * 25
+    followerGoal() {
+      const goal = this.findGoal('twitch', 'follow')
+      if (goal) {
+        return goal
+      }
+
+      return {
+        current: this.store.followers.count,
+        target: Math.ceil((this.store.followers.count + 1) / 25) * 25,
+      }
+    },
+
+    localGoals() {
+      return (this.store.goals || []).filter(goal => goal.source === 'local')
     },
 
-    nextSubs() {
-      return Math.ceil((this.store.subs.count + 1) / 5) * 5
+    subGoal() {
+      const goal = this.findGoal('twitch', 'subscription', 'subscription_count', 'new_subscription', 'new_subscription_count')
+      if (goal) {
+        return goal
+      }
+
+      return {
+        current: this.store.subs.points,
+        target: Math.ceil((this.store.subs.points + 1) / 5) * 5,
+      }
     },
   },
 
@@ -39,6 +59,7 @@ const app = new Vue({
       backoff: 100,
     },
     firstLoad: true,
+    profileImages: {},
     sound: null,
     store: {},
     socket: null,
@@ -49,11 +70,23 @@ const app = new Vue({
   el: '#app',
 
   methods: {
+    findGoal(source, ...types) {
+      return (this.store.goals || []).find(goal => goal.source === source && types.includes(goal.type))
+    },
+
     playSound(soundUrl) {
       this.sound.src = soundUrl
     },
 
-    showAlert(title, text, variant) {
+    showAlert(title, text, variant, image) {
+      if (image) {
+        const h = this.$createElement
+        text = h('div', { class: 'd-flex align-items-center' }, [
+          h('img', { attrs: { src: image, width: 48, height: 48 }, class: 'rounded-circle mr-2' }),
+          h('span', text),
+        ])
+      }
+
       this.$bvToast.toast(text, {
         title,
         toaster: 'b-toaster-top-right',
@@ -91,6 +124,13 @@ const app = new Vue({
             }
             break
 
+          case 'follow':
+            // Alert is triggered through the store, keep the avatar for it
+            if (data.payload.profile_image_url) {
+              this.profileImages[data.payload.from] = data.payload.profile_image_url
+            }
+            break
+
           case 'host':
             this.showAlert('Incoming host', `${data.payload.from} just hosted`)
             break
@@ -121,7 +161,7 @@ const app = new Vue({
         // Initial load or no follower
         return
       }
-      this.showAlert('New Follower', `${to} just followed`, 'success')
+      this.showAlert('New Follower', `${to} just followed`, 'success', this.profileImages[to])
       this.playSound('/public/doorbell.webm')
     },
 
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	helixHeaderRateLimitRemaining = "Ratelimit-Remaining"
+	helixHeaderRateLimitReset     = "Ratelimit-Reset"
+)
+
+// helixRateLimiter tracks the token bucket reported by the Helix API
+// and delays requests while the bucket is empty
+type helixRateLimiter struct {
+	lock      sync.Mutex
+	remaining int64
+	reset     time.Time
+}
+
+// Update reads the rate-limit headers of a response
+func (r *helixRateLimiter) Update(header http.Header) {
+	remaining, err := strconv.ParseInt(header.Get(helixHeaderRateLimitRemaining), 10, 64)
+	if err != nil {
+		// No or invalid rate-limit information
+		return
+	}
+
+	reset, err := strconv.ParseInt(header.Get(helixHeaderRateLimitReset), 10, 64)
+	if err != nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.remaining = remaining
+	r.reset = time.Unix(reset, 0)
+}
+
+// Wait blocks until the bucket has been refilled if it is empty
+func (r *helixRateLimiter) Wait(ctx context.Context) error {
+	r.lock.Lock()
+	wait := time.Until(r.reset)
+	if r.remaining > 0 || wait <= 0 {
+		r.lock.Unlock()
+		return nil
+	}
+	r.lock.Unlock()
+
+	log.WithField("wait", wait).Debug("Twitch API rate-limit exhausted, waiting for reset")
+
+	select {
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "waiting for reset")
+	case <-time.After(wait):
+		return nil
+	}
+}
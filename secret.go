@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const webhookSecretFileName = "webhook.secret"
+
+// loadWebhookSecret ensures the webhook secret survives restarts as
+// existing EventSub subscriptions are not re-created and would fail
+// signature verification with a new secret. It returns whether the
+// secret differs from the one used on the last run, which is also the
+// case if no secret was stored before as the previous one is unknown.
+func loadWebhookSecret() (bool, error) {
+	secretFile := filepath.Join(filepath.Dir(cfg.StoreFile), webhookSecretFileName)
+
+	raw, err := ioutil.ReadFile(secretFile)
+	if err != nil && !os.IsNotExist(err) {
+		return false, errors.Wrap(err, "reading secret file")
+	}
+	stored := strings.TrimSpace(string(raw))
+
+	switch {
+	case cfg.WebHookSecret == "" && stored != "":
+		cfg.WebHookSecret = stored
+		return false, nil
+
+	case cfg.WebHookSecret == "":
+		cfg.WebHookSecret = uuid.Must(uuid.NewV4()).String()
+
+	case cfg.WebHookSecret == stored:
+		return false, nil
+	}
+
+	return true, errors.Wrap(
+		ioutil.WriteFile(secretFile, []byte(cfg.WebHookSecret), 0o600),
+		"writing secret file",
+	)
+}
+
+// removeEventSubWebhookSubscriptions deletes all webhook subscriptions
+// in order to have them re-created using the current secret
+func removeEventSubWebhookSubscriptions() error {
+	subscriptionList, err := listEventSubSubscriptions(helixAuthApp)
+	if err != nil {
+		return errors.Wrap(err, "listing subscriptions")
+	}
+
+	for _, sub := range subscriptionList.Data {
+		if sub.Transport.Method != "webhook" || !sub.isOwn() {
+			continue
+		}
+
+		if err = deleteEventSubSubscription(sub.ID, helixAuthApp); err != nil {
+			return errors.Wrapf(err, "deleting subscription %s", sub.ID)
+		}
+
+		log.WithFields(log.Fields{
+			"id":   sub.ID,
+			"type": sub.Type,
+		}).Debug("Removed EventSub subscription with outdated secret")
+	}
+
+	return nil
+}
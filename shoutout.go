@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Twitch allows one shoutout every two minutes and one shoutout
+	// per target channel within an hour
+	shoutoutCooldown       = 2 * time.Minute
+	shoutoutTargetCooldown = time.Hour
+
+	shoutoutMaxAttempts = 3
+	shoutoutQueueSize   = 25
+)
+
+var (
+	shoutouts = newShoutoutQueue()
+
+	// raidChatTemplate is parsed from the RaidChatMessage on startup,
+	// nil if no message is configured
+	raidChatTemplate *template.Template
+)
+
+type (
+	shoutoutQueue struct {
+		lastShoutout time.Time
+		lastTarget   map[string]time.Time
+		queue        chan shoutoutTarget
+		lock         sync.Mutex
+	}
+
+	shoutoutTarget struct {
+		ID       string
+		Name     string
+		Attempts int
+	}
+)
+
+func newShoutoutQueue() *shoutoutQueue {
+	return &shoutoutQueue{
+		lastTarget: make(map[string]time.Time),
+		queue:      make(chan shoutoutTarget, shoutoutQueueSize),
+	}
+}
+
+// Enqueue schedules a shoutout for the given channel. Shoutouts are
+// executed in order as soon as the cooldown allows.
+func (s *shoutoutQueue) Enqueue(id, name string) {
+	s.enqueue(shoutoutTarget{ID: id, Name: name})
+}
+
+func (s *shoutoutQueue) enqueue(target shoutoutTarget) {
+	select {
+	case s.queue <- target:
+	default:
+		log.WithField("name", target.Name).Warn("Shoutout queue is full, dropping shoutout")
+	}
+}
+
+// Run processes the queue and never returns
+func (s *shoutoutQueue) Run() {
+	for target := range s.queue {
+		logger := log.WithField("name", target.Name)
+
+		s.lock.Lock()
+		if last, ok := s.lastTarget[target.ID]; ok && time.Since(last) < shoutoutTargetCooldown {
+			s.lock.Unlock()
+			logger.Info("Skipping shoutout, channel got one within the last hour")
+			continue
+		}
+		wait := shoutoutCooldown - time.Since(s.lastShoutout)
+		s.lock.Unlock()
+
+		if wait > 0 {
+			logger.WithField("wait", wait).Debug("Waiting for shoutout cooldown")
+			time.Sleep(wait)
+		}
+
+		if err := sendTwitchShoutout(context.Background(), target.ID); err != nil {
+			// Twitch rejects shoutouts during the cooldown or while the
+			// channel is offline: give it another try later
+			if target.Attempts++; target.Attempts < shoutoutMaxAttempts {
+				logger.WithError(err).WithField("retry_in", shoutoutCooldown).Warn("Unable to send shoutout, retrying")
+				time.AfterFunc(shoutoutCooldown, func() { s.enqueue(target) })
+				continue
+			}
+
+			logger.WithError(err).Error("Unable to send shoutout")
+			continue
+		}
+
+		s.lock.Lock()
+		s.lastShoutout = time.Now()
+		s.lastTarget[target.ID] = s.lastShoutout
+		s.lock.Unlock()
+
+		logger.Info("Sent shoutout")
+	}
+}
+
+// parseRaidChatTemplate parses the configured raid chat message and
+// renders it once with example values to detect errors on startup
+func parseRaidChatTemplate() error {
+	if cfg.RaidChatMessage == "" {
+		return nil
+	}
+
+	tpl, err := template.New("raid").Parse(cfg.RaidChatMessage)
+	if err != nil {
+		return errors.Wrap(err, "parsing chat message template")
+	}
+
+	if err = tpl.Execute(ioutil.Discard, map[string]interface{}{
+		"from":        "example",
+		"from_id":     "0",
+		"viewerCount": 1,
+		"game":        "Example",
+		"title":       "Example",
+	}); err != nil {
+		return errors.Wrap(err, "rendering chat message template")
+	}
+
+	raidChatTemplate = tpl
+	return nil
+}
+
+// announceRaidInChat renders the configured raid chat message and
+// queues it for the IRC connection
+func announceRaidInChat(fields map[string]interface{}) error {
+	if raidChatTemplate == nil {
+		return nil
+	}
+
+	buf := new(bytes.Buffer)
+	if err := raidChatTemplate.Execute(buf, fields); err != nil {
+		return errors.Wrap(err, "rendering chat message template")
+	}
+
+	sendChatMessage(buf.String())
+	return nil
+}
+
+func sendTwitchShoutout(ctx context.Context, toBroadcasterID string) error {
+	params := make(url.Values)
+	params.Set("from_broadcaster_id", cfg.TwitchID)
+	params.Set("to_broadcaster_id", toBroadcasterID)
+	params.Set("moderator_id", cfg.TwitchID)
+
+	return errors.Wrap(helix.Do(ctx, helixRequest{
+		Method: http.MethodPost,
+		Path:   "chat/shoutouts",
+		Params: params,
+		Auth:   helixAuthUser,
+		Expect: http.StatusNoContent,
+	}, nil), "requesting shoutout")
+}
@@ -3,10 +3,9 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/pkg/errors"
@@ -16,6 +15,8 @@ import (
 func updateStats() error {
 	log.Debug("Updating statistics from API")
 	for _, fn := range []func() error{
+		updateChannelInfo,
+		updateCreatorGoals,
 		updateFollowers,
 		updateSubscriberCount,
 		func() error { return subscriptions.SendAllSockets(msgTypeStore, store, false, false) },
@@ -28,48 +29,45 @@ func updateStats() error {
 	return nil
 }
 
+type twitchFollowerPage struct {
+	Total int64 `json:"total"`
+	Data  []struct {
+		UserID     string    `json:"user_id"`
+		UserLogin  string    `json:"user_login"`
+		UserName   string    `json:"user_name"`
+		FollowedAt time.Time `json:"followed_at"`
+	} `json:"data"`
+}
+
 func updateFollowers() error {
 	log.Debug("Updating followers from API")
-	ctx, cancel := context.WithTimeout(context.Background(), twitchRequestTimeout)
-	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://api.twitch.tv/helix/users/follows?to_id=%s", cfg.TwitchID), nil)
-	if err != nil {
-		return errors.Wrap(err, "assemble follower count request")
+	var payload twitchFollowerPage
+	if err := helix.Do(context.Background(), helixRequest{
+		Method: http.MethodGet,
+		Path:   "channels/followers",
+		Params: url.Values{
+			"broadcaster_id": []string{cfg.TwitchID},
+			"first":          []string{strconv.Itoa(storeMaxRecent)},
+		},
+		Auth: helixAuthUser,
+	}, &payload); err != nil {
+		return errors.Wrap(err, "requesting followers")
 	}
-	req.Header.Set("Client-Id", cfg.TwitchClient)
-	req.Header.Set("Authorization", "Bearer "+cfg.TwitchToken)
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return errors.Wrap(err, "requesting subscribe")
+	var (
+		ids  []string
+		seen []string
+	)
+	for _, f := range payload.Data {
+		ids = append(ids, f.UserID)
+		seen = append(seen, f.UserLogin)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return errors.Wrapf(err, "unexpected status %d, unable to read body", resp.StatusCode)
+	if knownFollowers.Add(ids...) {
+		if err := knownFollowers.Save(); err != nil {
+			return errors.Wrap(err, "save known followers")
 		}
-		return errors.Errorf("unexpected status %d: %s", resp.StatusCode, body)
-	}
-
-	payload := struct {
-		Total int64 `json:"total"`
-		Data  []struct {
-			FromName   string    `json:"from_name"`
-			FollowedAt time.Time `json:"followed_at"`
-		} `json:"data"`
-		// Contains more but I don't care.
-	}{}
-
-	if err = json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		return errors.Wrap(err, "decode json response")
-	}
-
-	var seen []string
-	for _, f := range payload.Data {
-		seen = append(seen, f.FromName)
 	}
 
 	store.WithModLock(func() error {
@@ -82,73 +80,117 @@ func updateFollowers() error {
 	return errors.Wrap(store.Save(cfg.StoreFile), "save store")
 }
 
-func updateSubscriberCount() error {
-	log.Debug("Updating subscriber count from API")
+// syncAllFollowers walks all pages of followers and adds them to the
+// known followers in order to never alert for them again
+func syncAllFollowers() error {
+	log.Debug("Fetching all followers from API")
+
+	var ids []string
+
+	if err := helix.Paginate(context.Background(), helixRequest{
+		Method: http.MethodGet,
+		Path:   "channels/followers",
+		Params: url.Values{
+			"broadcaster_id": []string{cfg.TwitchID},
+			"first":          []string{"100"},
+		},
+		Auth: helixAuthUser,
+	}, func(page json.RawMessage) error {
+		var payload twitchFollowerPage
+		if err := json.Unmarshal(page, &payload); err != nil {
+			return errors.Wrap(err, "decode json response")
+		}
 
-	var (
-		params   = url.Values{"broadcaster_id": []string{cfg.TwitchID}}
-		subCount int64
-	)
+		for _, f := range payload.Data {
+			ids = append(ids, f.UserID)
+		}
 
-	for {
-		ctx, cancel := context.WithTimeout(context.Background(), twitchRequestTimeout)
-		defer cancel()
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "requesting followers")
+	}
 
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://api.twitch.tv/helix/subscriptions?%s", params.Encode()), nil)
-		if err != nil {
-			return errors.Wrap(err, "assemble subscriber request")
-		}
-		req.Header.Set("Client-Id", cfg.TwitchClient)
-		req.Header.Set("Authorization", "Bearer "+cfg.TwitchToken)
+	knownFollowers.Add(ids...)
+	log.WithField("followers", len(ids)).Info("Synced all followers")
 
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return errors.Wrap(err, "requesting subscribe")
-		}
-		defer resp.Body.Close()
+	return errors.Wrap(knownFollowers.Save(), "save known followers")
+}
 
-		if resp.StatusCode != http.StatusOK {
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				return errors.Wrapf(err, "unexpected status %d, unable to read body", resp.StatusCode)
-			}
-			return errors.Errorf("unexpected status %d: %s", resp.StatusCode, body)
-		}
+// subTierPoints maps the subscription tiers to the sub points they
+// are worth
+var subTierPoints = map[string]int64{
+	"1000": 1,
+	"2000": 2,
+	"3000": 6,
+}
 
+func updateSubscriberCount() error {
+	log.Debug("Updating subscriber count from API")
+
+	var (
+		gifted, paid, total, points int64
+		selfPoints, selfSubs        int64
+		firstPage                   = true
+		tiers                       = make(map[string]int64)
+	)
+
+	if err := helix.Paginate(context.Background(), helixRequest{
+		Method: http.MethodGet,
+		Path:   "subscriptions",
+		Params: url.Values{
+			"broadcaster_id": []string{cfg.TwitchID},
+			"first":          []string{"100"},
+		},
+		Auth: helixAuthUser,
+	}, func(page json.RawMessage) error {
 		payload := struct {
-			Pagination struct {
-				Cursor string `json:"cursor"`
-			} `json:"pagination"`
 			Data []struct {
 				BroadcasterID string `json:"broadcaster_id"`
+				IsGift        bool   `json:"is_gift"`
 				Tier          string `json:"tier"`
 				UserID        string `json:"user_id"`
 			} `json:"data"`
+			Points int64 `json:"points"`
+			Total  int64 `json:"total"`
 			// Contains more but I don't care.
 		}{}
 
-		if err = json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		if err := json.Unmarshal(page, &payload); err != nil {
 			return errors.Wrap(err, "decode json response")
 		}
 
-		if len(payload.Data) == 0 {
-			break
+		if firstPage {
+			total, points = payload.Total, payload.Points
+			firstPage = false
 		}
 
 		for _, sub := range payload.Data {
 			if sub.UserID == sub.BroadcasterID {
 				// Don't count self
+				selfSubs++
+				selfPoints += subTierPoints[sub.Tier]
 				continue
 			}
 
-			subCount++
+			tiers[sub.Tier]++
+			if sub.IsGift {
+				gifted++
+			} else {
+				paid++
+			}
 		}
 
-		params.Set("after", payload.Pagination.Cursor)
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "requesting subscribers")
 	}
 
 	store.WithModLock(func() error {
-		store.Subs.Count = subCount
+		store.Subs.Count = total - selfSubs
+		store.Subs.Gifted = gifted
+		store.Subs.Paid = paid
+		store.Subs.Points = points - selfPoints
+		store.Subs.Tiers = tiers
 
 		return nil
 	})
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Luzifer/twitch-manager/internal/faketwitch"
+)
+
+func TestUpdateStats(t *testing.T) {
+	env := newTestEnv(t, "channel:read:goals", "channel:read:subscriptions", "moderator:read:followers")
+
+	for _, u := range []faketwitch.User{
+		{ID: "2000", Login: "alice", DisplayName: "Alice"},
+		{ID: "2001", Login: "bob", DisplayName: "Bob"},
+		{ID: "2002", Login: "carol", DisplayName: "Carol"},
+	} {
+		env.fake.AddUser(u)
+		if err := env.fake.AddFollower(u.ID); err != nil {
+			t.Fatalf("adding follower: %s", err)
+		}
+	}
+
+	for _, sub := range []faketwitch.Subscription{
+		{UserID: testBroadcaster.ID, Tier: "3000"},
+		{UserID: "2000", Tier: "1000"},
+		{UserID: "2001", Tier: "1000", IsGift: true},
+		{UserID: "2002", Tier: "2000"},
+	} {
+		if err := env.fake.AddSubscription(sub); err != nil {
+			t.Fatalf("adding subscription: %s", err)
+		}
+	}
+
+	env.fake.SetChannel(faketwitch.Channel{GameID: "509658", GameName: "Just Chatting", Language: "en", Title: "Testing"})
+	env.fake.SetGoals(faketwitch.Goal{
+		ID:            "goal1",
+		Type:          "follower",
+		Description:   "More followers",
+		CurrentAmount: 3,
+		TargetAmount:  10,
+		CreatedAt:     time.Now().Add(-time.Hour),
+	})
+
+	store.Goals = []goal{
+		{ID: "stale", Source: goalSourceTwitch, Type: "follower"},
+		{ID: "local-bits", Source: goalSourceLocal, Type: goalTypeBits, Target: 1000},
+	}
+
+	if err := updateStats(); err != nil {
+		t.Fatalf("updating stats: %s", err)
+	}
+
+	if store.Followers.Count != 3 {
+		t.Errorf("expected 3 followers, got %d", store.Followers.Count)
+	}
+	if l := len(store.Followers.Seen); l != 3 || store.Followers.Seen[0] != "carol" {
+		t.Errorf("expected newest follower first, got %v", store.Followers.Seen)
+	}
+
+	// The broadcaster subscribing themselves is not counted
+	if store.Subs.Count != 3 || store.Subs.Paid != 2 || store.Subs.Gifted != 1 {
+		t.Errorf("expected 3 subs (2 paid, 1 gifted), got %d (%d paid, %d gifted)", store.Subs.Count, store.Subs.Paid, store.Subs.Gifted)
+	}
+	if store.Subs.Points != 4 {
+		t.Errorf("expected 4 sub points, got %d", store.Subs.Points)
+	}
+	if store.Subs.Tiers["1000"] != 2 || store.Subs.Tiers["2000"] != 1 || store.Subs.Tiers["3000"] != 0 {
+		t.Errorf("unexpected tiers: %v", store.Subs.Tiers)
+	}
+
+	if store.Channel.Title != "Testing" || store.Channel.CategoryName != "Just Chatting" || store.Channel.Language != "en" {
+		t.Errorf("unexpected channel info: %+v", store.Channel)
+	}
+
+	var ids []string
+	for _, g := range store.Goals {
+		ids = append(ids, g.ID)
+	}
+	if len(ids) != 2 || ids[0] != "local-bits" || ids[1] != "goal1" {
+		t.Errorf("expected local and fetched goal, got %v", ids)
+	}
+
+	// The Helix type is stored using the EventSub spelling the overlay uses
+	if g := store.Goals[len(store.Goals)-1]; g.Type != "follow" {
+		t.Errorf("expected follower goal type to be normalized, got %q", g.Type)
+	}
+
+	if len(env.socketMessages(msgTypeStore)) != 1 {
+		t.Error("expected store to be sent to the sockets")
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"compress/gzip"
 	"encoding/json"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sort"
 	"sync"
 	"time"
@@ -11,13 +13,111 @@ import (
 	"github.com/pkg/errors"
 )
 
-const storeMaxRecent = 50
+const (
+	storeMaxRecent         = 50
+	storeMaxSeenMessageIDs = 1000
+)
 
 type subscriber struct {
 	Name   string `json:"name"`
+	Login  string `json:"login"`
+	UserID string `json:"user_id"`
 	Months int64  `json:"months"`
 }
 
+type hypeTrain struct {
+	ID               string                  `json:"id"`
+	Active           bool                    `json:"active"`
+	Level            int64                   `json:"level"`
+	Total            int64                   `json:"total"`
+	Progress         int64                   `json:"progress"`
+	Goal             int64                   `json:"goal"`
+	TopContributions []hypeTrainContribution `json:"top_contributions"`
+	StartedAt        *time.Time              `json:"started_at,omitempty"`
+	ExpiresAt        *time.Time              `json:"expires_at,omitempty"`
+	EndedAt          *time.Time              `json:"ended_at,omitempty"`
+	CooldownEndsAt   *time.Time              `json:"cooldown_ends_at,omitempty"`
+}
+
+type hypeTrainContribution struct {
+	User  string `json:"user"`
+	Type  string `json:"type"`
+	Total int64  `json:"total"`
+}
+
+type poll struct {
+	ID        string       `json:"id"`
+	Title     string       `json:"title"`
+	Status    string       `json:"status"`
+	Choices   []pollChoice `json:"choices"`
+	StartedAt *time.Time   `json:"started_at,omitempty"`
+	EndsAt    *time.Time   `json:"ends_at,omitempty"`
+	EndedAt   *time.Time   `json:"ended_at,omitempty"`
+}
+
+type pollChoice struct {
+	ID                 string `json:"id"`
+	Title              string `json:"title"`
+	Votes              int64  `json:"votes"`
+	BitsVotes          int64  `json:"bits_votes"`
+	ChannelPointsVotes int64  `json:"channel_points_votes"`
+}
+
+type prediction struct {
+	ID               string              `json:"id"`
+	Title            string              `json:"title"`
+	Status           string              `json:"status"`
+	Outcomes         []predictionOutcome `json:"outcomes"`
+	WinningOutcomeID string              `json:"winning_outcome_id,omitempty"`
+	StartedAt        *time.Time          `json:"started_at,omitempty"`
+	LocksAt          *time.Time          `json:"locks_at,omitempty"`
+	LockedAt         *time.Time          `json:"locked_at,omitempty"`
+	EndedAt          *time.Time          `json:"ended_at,omitempty"`
+}
+
+type predictionOutcome struct {
+	ID            string `json:"id"`
+	Title         string `json:"title"`
+	Color         string `json:"color"`
+	Users         int64  `json:"users"`
+	ChannelPoints int64  `json:"channel_points"`
+}
+
+type raid struct {
+	Name    string    `json:"name"`
+	Viewers int64     `json:"viewers"`
+	Game    string    `json:"game"`
+	Title   string    `json:"title"`
+	Time    time.Time `json:"time"`
+}
+
+type redemption struct {
+	ID          string    `json:"id"`
+	RewardID    string    `json:"reward_id"`
+	RewardTitle string    `json:"reward_title"`
+	Cost        int64     `json:"cost"`
+	User        string    `json:"user"`
+	Input       string    `json:"input"`
+	Status      string    `json:"status"`
+	RedeemedAt  time.Time `json:"redeemed_at"`
+}
+
+// streamSession contains the counters of the current stream, they are
+// only increased while the stream is live
+type streamSession struct {
+	ID        string     `json:"id"`
+	Live      bool       `json:"live"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+
+	Bits      int64   `json:"bits"`
+	Donations float64 `json:"donations"`
+	Follows   int64   `json:"follows"`
+	Raids     int64   `json:"raids"`
+	SubGifts  int64   `json:"sub_gifts"`
+	Subs      int64   `json:"subs"`
+}
+
 type storedEvent struct {
 	Time    time.Time
 	Type    string
@@ -30,6 +130,12 @@ type storage struct {
 		LastAmount   int64            `json:"last_amount"`
 		TotalAmounts map[string]int64 `json:"total_amounts"`
 	} `json:"bit_donations"`
+	Channel struct {
+		Title        string `json:"title"`
+		Language     string `json:"language"`
+		CategoryID   string `json:"category_id"`
+		CategoryName string `json:"category_name"`
+	} `json:"channel"`
 	Donations struct {
 		LastDonator *string `json:"last_donator"`
 		LastAmount  float64 `json:"last_amount"`
@@ -39,21 +145,46 @@ type storage struct {
 		Seen  []string `json:"seen"`
 		Count int64    `json:"count"`
 	} `json:"followers"`
-	Subs struct {
-		Last         *string      `json:"last"`
-		LastDuration int64        `json:"last_duration"`
-		Count        int64        `json:"count"`
-		Recent       []subscriber `json:"recent"`
+	Goals      []goal      `json:"goals"`
+	HypeTrain  hypeTrain   `json:"hype_train"`
+	Poll       *poll       `json:"poll"`
+	Prediction *prediction `json:"prediction"`
+	Raids      struct {
+		Recent []raid `json:"recent"`
+	} `json:"raids"`
+	Redemptions struct {
+		Recent []redemption `json:"recent"`
+	} `json:"redemptions"`
+	Session streamSession `json:"session"`
+	Subs    struct {
+		Last         *string          `json:"last"`
+		LastDuration int64            `json:"last_duration"`
+		Count        int64            `json:"count"`
+		Gifted       int64            `json:"gifted"`
+		Paid         int64            `json:"paid"`
+		Points       int64            `json:"points"`
+		Tiers        map[string]int64 `json:"tiers"`
+		Recent       []subscriber     `json:"recent"`
 	} `json:"subs"`
 
 	Events []storedEvent
 
+	// EventSubMessageIDs is persisted separately as it is not needed
+	// by the overlay and would bloat every store update sent to it
+	EventSubMessageIDs []string `json:"-"`
+
 	modLock  sync.RWMutex
 	saveLock sync.Mutex
 }
 
 func newStorage() *storage { return &storage{} }
 
+// storeMessageIDFile returns the file the seen EventSub message IDs
+// are persisted to next to the store file
+func storeMessageIDFile(storeFile string) string {
+	return filepath.Join(filepath.Dir(storeFile), "eventsub-message-ids.json")
+}
+
 func (s *storage) Load(from string) error {
 	f, err := os.Open(from)
 	if err != nil {
@@ -70,10 +201,19 @@ func (s *storage) Load(from string) error {
 	}
 	defer gf.Close()
 
-	return errors.Wrap(
-		json.NewDecoder(gf).Decode(s),
-		"decode json",
-	)
+	if err = json.NewDecoder(gf).Decode(s); err != nil {
+		return errors.Wrap(err, "decode json")
+	}
+
+	raw, err := ioutil.ReadFile(storeMessageIDFile(from))
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return errors.Wrap(err, "reading message ID file")
+	}
+
+	return errors.Wrap(json.Unmarshal(raw, &s.EventSubMessageIDs), "decode message IDs")
 }
 
 func (s *storage) Save(to string) error {
@@ -87,15 +227,36 @@ func (s *storage) Save(to string) error {
 		s.Followers.Seen = s.Followers.Seen[:storeMaxRecent]
 	}
 
+	if len(s.Raids.Recent) > storeMaxRecent {
+		s.Raids.Recent = s.Raids.Recent[:storeMaxRecent]
+	}
+
+	if len(s.Redemptions.Recent) > storeMaxRecent {
+		s.Redemptions.Recent = s.Redemptions.Recent[:storeMaxRecent]
+	}
+
 	if len(s.Subs.Recent) > storeMaxRecent {
 		s.Subs.Recent = s.Subs.Recent[:storeMaxRecent]
 	}
 
+	if len(s.EventSubMessageIDs) > storeMaxSeenMessageIDs {
+		s.EventSubMessageIDs = s.EventSubMessageIDs[:storeMaxSeenMessageIDs]
+	}
+
 	sort.Slice(s.Events, func(j, i int) bool { return s.Events[i].Time.Before(s.Events[j].Time) })
 	if len(s.Events) > storeMaxRecent {
 		s.Events = s.Events[:storeMaxRecent]
 	}
 
+	rawIDs, err := json.Marshal(s.EventSubMessageIDs)
+	if err != nil {
+		return errors.Wrap(err, "encode message IDs")
+	}
+
+	if err = ioutil.WriteFile(storeMessageIDFile(to), rawIDs, 0o644); err != nil {
+		return errors.Wrap(err, "write message ID file")
+	}
+
 	f, err := os.Create(to)
 	if err != nil {
 		return errors.Wrap(err, "create file")
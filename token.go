@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// appAccessTokenRenewBefore defines how long before the expiry of the
+// app-access-token a new one is requested
+const appAccessTokenRenewBefore = 5 * time.Minute
+
+var appAccessTokens = new(appAccessTokenManager)
+
+// appAccessTokenManager caches the client-credentials token and
+// requests a new one when it is about to expire or got invalidated
+type appAccessTokenManager struct {
+	expiresAt time.Time
+	lock      sync.Mutex
+	token     string
+}
+
+// Get returns a valid app-access-token, requesting a new one if required
+func (a *appAccessTokenManager) Get(ctx context.Context) (string, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.token != "" && time.Now().Before(a.expiresAt.Add(-appAccessTokenRenewBefore)) {
+		return a.token, nil
+	}
+
+	var rData struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		TokenType   string `json:"token_type"`
+	}
+
+	params := make(url.Values)
+	params.Set("client_id", cfg.TwitchClient)
+	params.Set("client_secret", cfg.TwitchSecret)
+	params.Set("grant_type", "client_credentials")
+
+	if err := helix.DoID(ctx, http.MethodPost, "token", params, "", &rData); err != nil {
+		return "", errors.Wrap(err, "requesting token")
+	}
+
+	if rData.AccessToken == "" {
+		return "", errors.New("empty token returned")
+	}
+
+	a.token = rData.AccessToken
+	a.expiresAt = time.Now().Add(time.Duration(rData.ExpiresIn) * time.Second)
+
+	log.WithField("expires_at", a.expiresAt).Debug("Fetched new app-access-token")
+	return a.token, nil
+}
+
+// Invalidate drops the cached token so the next call to Get requests
+// a new one, i.e. after the API rejected the token
+func (a *appAccessTokenManager) Invalidate() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.token = ""
+}
+
+// userAccessTokenManager holds the user-access-token obtained through
+// the authorization-code flow and refreshes it using the refresh-token.
+// Without a completed flow the static token from the config is used.
+type userAccessTokenManager struct {
+	accessToken  string
+	expiresAt    time.Time
+	lock         sync.Mutex
+	refreshToken string
+}
+
+var userAccessTokens = new(userAccessTokenManager)
+
+// userTokenChanged is notified whenever a new user-access-token was
+// obtained so long-lived connections can pick it up
+var userTokenChanged = make(chan struct{}, 1)
+
+type oauthTokenResponse struct {
+	AccessToken  string   `json:"access_token"`
+	RefreshToken string   `json:"refresh_token"`
+	ExpiresIn    int64    `json:"expires_in"`
+	Scope        []string `json:"scope"`
+	TokenType    string   `json:"token_type"`
+}
+
+// Get returns a valid user-access-token, refreshing it if required
+func (u *userAccessTokenManager) Get(ctx context.Context) (string, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if u.refreshToken == "" {
+		return cfg.TwitchToken, nil
+	}
+
+	if u.accessToken != "" && time.Now().Before(u.expiresAt.Add(-appAccessTokenRenewBefore)) {
+		return u.accessToken, nil
+	}
+
+	params := make(url.Values)
+	params.Set("client_id", cfg.TwitchClient)
+	params.Set("client_secret", cfg.TwitchSecret)
+	params.Set("grant_type", "refresh_token")
+	params.Set("refresh_token", u.refreshToken)
+
+	var rData oauthTokenResponse
+	if err := helix.DoID(ctx, http.MethodPost, "token", params, "", &rData); err != nil {
+		return "", errors.Wrap(err, "refreshing token")
+	}
+
+	if err := u.set(rData); err != nil {
+		return "", errors.Wrap(err, "storing refreshed token")
+	}
+
+	log.WithField("expires_at", u.expiresAt).Debug("Refreshed user-access-token")
+	return u.accessToken, nil
+}
+
+// Invalidate drops the cached access-token so the next call to Get
+// refreshes it. Returns false if the token cannot be refreshed.
+func (u *userAccessTokenManager) Invalidate() bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	u.accessToken = ""
+	return u.refreshToken != ""
+}
+
+// Refreshable returns whether a refresh-token is available
+func (u *userAccessTokenManager) Refreshable() bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	return u.refreshToken != ""
+}
+
+// Load reads a previously persisted token
+func (u *userAccessTokenManager) Load() error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	var rData oauthTokenResponse
+	if err := readEncryptedFile(userTokenFile(), &rData); err != nil {
+		return err
+	}
+
+	u.accessToken = ""
+	u.refreshToken = rData.RefreshToken
+	return nil
+}
+
+// Set stores a token obtained through the authorization-code flow
+// and notifies listeners about the change
+func (u *userAccessTokenManager) Set(rData oauthTokenResponse) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if err := u.set(rData); err != nil {
+		return err
+	}
+
+	select {
+	case userTokenChanged <- struct{}{}:
+	default:
+		// Notification already pending
+	}
+
+	return nil
+}
+
+func (u *userAccessTokenManager) set(rData oauthTokenResponse) error {
+	u.accessToken = rData.AccessToken
+	u.expiresAt = time.Now().Add(time.Duration(rData.ExpiresIn) * time.Second)
+	if rData.RefreshToken != "" {
+		u.refreshToken = rData.RefreshToken
+	}
+
+	return errors.Wrap(
+		writeEncryptedFile(userTokenFile(), oauthTokenResponse{RefreshToken: u.refreshToken}),
+		"persisting refresh-token",
+	)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Luzifer/go_helpers/v2/str"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// tokenExpiryWarning defines how long before the expiry of a token
+// which cannot be refreshed a warning is logged
+const tokenExpiryWarning = 72 * time.Hour
+
+var tokenStatus = new(tokenStatusStore)
+
+type (
+	// twitchFeature describes a part of the service and the scopes the
+	// user-access-token needs to have for it to work
+	twitchFeature struct {
+		Name    string
+		Scopes  []string
+		Enabled func() bool
+	}
+
+	tokenValidation struct {
+		ClientID  string   `json:"client_id"`
+		Login     string   `json:"login"`
+		Scopes    []string `json:"scopes"`
+		UserID    string   `json:"user_id"`
+		ExpiresIn int64    `json:"expires_in"`
+	}
+
+	tokenStatusEntry struct {
+		Valid         bool                `json:"valid"`
+		Login         string              `json:"login,omitempty"`
+		UserID        string              `json:"user_id,omitempty"`
+		Scopes        []string            `json:"scopes,omitempty"`
+		MissingScopes map[string][]string `json:"missing_scopes,omitempty"`
+		ExpiresAt     *time.Time          `json:"expires_at,omitempty"`
+		Refreshable   bool                `json:"refreshable"`
+		Message       string              `json:"message,omitempty"`
+		CheckedAt     time.Time           `json:"checked_at"`
+	}
+
+	tokenStatusStore struct {
+		entry tokenStatusEntry
+		lock  sync.RWMutex
+	}
+)
+
+func featureAlwaysEnabled() bool { return true }
+
+var twitchFeatures = []twitchFeature{
+	{Name: "bits", Scopes: []string{"bits:read"}, Enabled: featureAlwaysEnabled},
+	{Name: "chat", Scopes: []string{"chat:read"}, Enabled: featureAlwaysEnabled},
+	{Name: "followers", Scopes: []string{"moderator:read:followers"}, Enabled: featureAlwaysEnabled},
+	{Name: "goals", Scopes: []string{"channel:read:goals"}, Enabled: featureAlwaysEnabled},
+	{Name: "hype-train", Scopes: []string{"channel:read:hype_train"}, Enabled: featureAlwaysEnabled},
+	{Name: "moderation", Scopes: []string{"channel:moderate"}, Enabled: featureAlwaysEnabled},
+	{Name: "polls", Scopes: []string{"channel:read:polls"}, Enabled: featureAlwaysEnabled},
+	{Name: "predictions", Scopes: []string{"channel:read:predictions"}, Enabled: featureAlwaysEnabled},
+	{Name: "raid-chat-message", Scopes: []string{"chat:edit"}, Enabled: func() bool { return cfg.RaidChatMessage != "" }},
+	{Name: "raid-shoutout", Scopes: []string{"moderator:manage:shoutouts"}, Enabled: func() bool { return cfg.RaidShoutout }},
+	{Name: "redemptions", Scopes: []string{"channel:manage:redemptions", "channel:read:redemptions"}, Enabled: featureAlwaysEnabled},
+	{Name: "subscriptions", Scopes: []string{"channel:read:subscriptions"}, Enabled: featureAlwaysEnabled},
+}
+
+// twitchRequiredScopes returns the scopes needed by all features, not
+// only the enabled ones, so enabling a feature does not require a new
+// authorization
+func twitchRequiredScopes() []string {
+	var scopes []string
+	for _, f := range twitchFeatures {
+		for _, s := range f.Scopes {
+			if !str.StringInSlice(s, scopes) {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+
+	sort.Strings(scopes)
+	return scopes
+}
+
+// missingScopesByFeature lists the scopes missing for each enabled feature
+func missingScopesByFeature(granted []string) map[string][]string {
+	out := make(map[string][]string)
+	for _, f := range twitchFeatures {
+		if !f.Enabled() {
+			continue
+		}
+
+		for _, s := range f.Scopes {
+			if !str.StringInSlice(s, granted) {
+				out[f.Name] = append(out[f.Name], s)
+			}
+		}
+	}
+
+	return out
+}
+
+// validateToken asks Twitch for the details of the given token
+func validateToken(ctx context.Context, token string) (tokenValidation, error) {
+	var out tokenValidation
+	return out, errors.Wrap(
+		helix.DoID(ctx, http.MethodGet, "validate", nil, token, &out),
+		"requesting validation",
+	)
+}
+
+func (t *tokenStatusStore) Get() tokenStatusEntry {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.entry
+}
+
+func (t *tokenStatusStore) set(e tokenStatusEntry) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	e.CheckedAt = time.Now()
+	t.entry = e
+}
+
+// checkUserToken validates the current user-access-token, logs all
+// problems found and updates the status reported by the API
+func checkUserToken() error {
+	ctx := context.Background()
+	status := tokenStatusEntry{Refreshable: userAccessTokens.Refreshable()}
+
+	token, err := userAccessTokens.Get(ctx)
+	if err == nil && token == "" {
+		err = errors.New("no token available, authorize using /auth/login")
+	}
+	if err != nil {
+		status.Message = err.Error()
+		tokenStatus.set(status)
+		return errors.Wrap(err, "getting user-access-token")
+	}
+
+	validation, err := validateToken(ctx, token)
+	if isHelixStatus(err, http.StatusUnauthorized) && userAccessTokens.Invalidate() {
+		// Token might have been revoked, try with a refreshed one
+		if token, err = userAccessTokens.Get(ctx); err == nil {
+			validation, err = validateToken(ctx, token)
+		}
+	}
+
+	if err != nil {
+		status.Message = "token is invalid or expired"
+		if !isHelixStatus(err, http.StatusUnauthorized) {
+			status.Message = err.Error()
+		}
+		tokenStatus.set(status)
+		return errors.Wrap(err, "validating user-access-token")
+	}
+
+	status.Login = validation.Login
+	status.UserID = validation.UserID
+	status.Scopes = validation.Scopes
+	status.MissingScopes = missingScopesByFeature(validation.Scopes)
+	status.Valid = validation.UserID == cfg.TwitchID
+
+	logger := log.WithFields(log.Fields{
+		"login":   validation.Login,
+		"user_id": validation.UserID,
+	})
+
+	if validation.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(validation.ExpiresIn) * time.Second)
+		status.ExpiresAt = &expiresAt
+		logger = logger.WithField("expires_at", expiresAt)
+	}
+
+	if !status.Valid {
+		status.Message = "token belongs to another user than configured in twitch-id"
+		tokenStatus.set(status)
+		return errors.Errorf("token belongs to user %s, expected %s", validation.UserID, cfg.TwitchID)
+	}
+
+	tokenStatus.set(status)
+
+	for feature, scopes := range status.MissingScopes {
+		logger.WithFields(log.Fields{
+			"feature": feature,
+			"missing": scopes,
+		}).Warn("User-access-token is missing scopes required for feature")
+	}
+
+	if status.ExpiresAt != nil && !status.Refreshable && time.Until(*status.ExpiresAt) < tokenExpiryWarning {
+		logger.Warn("User-access-token expires soon, authorize using /auth/login")
+	}
+
+	logger.Info("Validated user-access-token")
+	return nil
+}
+
+func handleTokenStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tokenStatus.Get()); err != nil {
+		http.Error(w, errors.Wrap(err, "encoding status").Error(), http.StatusInternalServerError)
+	}
+}
@@ -2,48 +2,79 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 )
 
-func getTwitchAppAccessToken(ctx context.Context) (string, error) {
-	var rData struct {
-		AccessToken  string        `json:"access_token"`
-		RefreshToken string        `json:"refresh_token"`
-		ExpiresIn    int           `json:"expires_in"`
-		Scope        []interface{} `json:"scope"`
-		TokenType    string        `json:"token_type"`
+type twitchChannelInfo struct {
+	BroadcasterID       string `json:"broadcaster_id"`
+	BroadcasterLogin    string `json:"broadcaster_login"`
+	BroadcasterName     string `json:"broadcaster_name"`
+	BroadcasterLanguage string `json:"broadcaster_language"`
+	GameID              string `json:"game_id"`
+	GameName            string `json:"game_name"`
+	Title               string `json:"title"`
+}
+
+func getTwitchChannelInfo(ctx context.Context, broadcasterID string) (twitchChannelInfo, error) {
+	var payload struct {
+		Data []twitchChannelInfo `json:"data"`
 	}
 
-	params := make(url.Values)
-	params.Set("client_id", cfg.TwitchClient)
-	params.Set("client_secret", cfg.TwitchSecret)
-	params.Set("grant_type", "client_credentials")
+	if err := helix.Do(ctx, helixRequest{
+		Method: http.MethodGet,
+		Path:   "channels",
+		Params: url.Values{"broadcaster_id": []string{broadcasterID}},
+		Auth:   helixAuthUser,
+	}, &payload); err != nil {
+		return twitchChannelInfo{}, errors.Wrap(err, "requesting channel info")
+	}
+
+	if l := len(payload.Data); l != 1 {
+		return twitchChannelInfo{}, errors.Errorf("unexpected number of channels returned: %d", l)
+	}
+
+	return payload.Data[0], nil
+}
 
-	u, _ := url.Parse("https://id.twitch.tv/oauth2/token")
-	u.RawQuery = params.Encode()
+// updateChannelInfo fetches the channel metadata as channel.update
+// events are only sent on changes
+func updateChannelInfo() error {
+	log.Debug("Updating channel info from API")
 
-	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), nil)
-	resp, err := http.DefaultClient.Do(req)
+	ctx, cancel := context.WithTimeout(context.Background(), twitchRequestTimeout)
+	defer cancel()
+
+	channel, err := getTwitchChannelInfo(ctx, cfg.TwitchID)
 	if err != nil {
-		return "", errors.Wrap(err, "fetching response")
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return "", errors.Wrapf(err, "unexpected status %d and cannot read body", resp.StatusCode)
-		}
-		return "", errors.Errorf("unexpected status %d: %s", resp.StatusCode, body)
+		return errors.Wrap(err, "getting channel info")
 	}
 
-	return rData.AccessToken, errors.Wrap(
-		json.NewDecoder(resp.Body).Decode(&rData),
-		"decoding response",
-	)
+	store.WithModLock(func() error {
+		store.Channel.Title = channel.Title
+		store.Channel.Language = channel.BroadcasterLanguage
+		store.Channel.CategoryID = channel.GameID
+		store.Channel.CategoryName = channel.GameName
+		return nil
+	})
+
+	return errors.Wrap(store.Save(cfg.StoreFile), "save store")
+}
+
+func updateTwitchRedemptionStatus(ctx context.Context, rewardID, redemptionID, status string) error {
+	params := make(url.Values)
+	params.Set("broadcaster_id", cfg.TwitchID)
+	params.Set("reward_id", rewardID)
+	params.Set("id", redemptionID)
+
+	return errors.Wrap(helix.Do(ctx, helixRequest{
+		Method: http.MethodPatch,
+		Path:   "channel_points/custom_rewards/redemptions",
+		Params: params,
+		Auth:   helixAuthUser,
+		Body:   map[string]string{"status": status},
+	}, nil), "requesting redemption update")
 }
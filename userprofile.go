@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Luzifer/go_helpers/v2/str"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Helix allows up to 100 IDs and logins combined per request
+	userProfileBatchSize      = 100
+	userProfileBatchWait      = 100 * time.Millisecond
+	userProfileCacheTTL       = 6 * time.Hour
+	userProfileEnrichTimeout  = 500 * time.Millisecond
+	userProfileEvictInterval  = time.Hour
+	userProfileFailureBackoff = time.Minute
+	userProfileLookupTimeout  = 2 * time.Second
+)
+
+var userProfiles = newUserProfileService()
+
+type (
+	userProfile struct {
+		ID              string    `json:"id"`
+		Login           string    `json:"login"`
+		DisplayName     string    `json:"display_name"`
+		BroadcasterType string    `json:"broadcaster_type"`
+		ProfileImageURL string    `json:"profile_image_url"`
+		CreatedAt       time.Time `json:"created_at"`
+	}
+
+	cachedUserProfile struct {
+		profile   userProfile
+		expiresAt time.Time
+	}
+
+	userProfileRequest struct {
+		ID     string
+		Login  string
+		result chan error
+	}
+
+	// userProfileService looks up users through the Helix API. Lookups
+	// issued within a short window are combined into one request and
+	// the results are cached.
+	userProfileService struct {
+		cache       map[string]cachedUserProfile
+		failedUntil time.Time
+		lock        sync.RWMutex
+		requests    chan userProfileRequest
+	}
+)
+
+func newUserProfileService() *userProfileService {
+	return &userProfileService{
+		cache:    make(map[string]cachedUserProfile),
+		requests: make(chan userProfileRequest, userProfileBatchSize),
+	}
+}
+
+// Enrich adds the profile of the given user to the alert fields. The
+// ID is preferred over the login, failed lookups are only logged as
+// the alert should be sent nevertheless. In order not to delay alerts
+// the lookup is only awaited for a short time (it still fills the
+// cache when finished later) and skipped while the API is failing.
+func (u *userProfileService) Enrich(fields map[string]interface{}, id, login string) {
+	if id == "" && login == "" {
+		// Anonymous event
+		return
+	}
+
+	if u.isFailing() {
+		if p, ok := u.cached(userProfileKey(id, login)); ok {
+			setUserProfileFields(fields, p)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), userProfileEnrichTimeout)
+	defer cancel()
+
+	profile, err := u.Get(ctx, id, login)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"id":    id,
+			"login": login,
+		}).Warn("Unable to fetch user profile")
+		return
+	}
+
+	setUserProfileFields(fields, profile)
+}
+
+// Get returns the profile of the user from cache or fetches it
+func (u *userProfileService) Get(ctx context.Context, id, login string) (userProfile, error) {
+	key := userProfileKey(id, login)
+
+	if p, ok := u.cached(key); ok {
+		return p, nil
+	}
+
+	req := userProfileRequest{ID: id, Login: strings.ToLower(login), result: make(chan error, 1)}
+	if id != "" {
+		req.Login = ""
+	}
+
+	select {
+	case u.requests <- req:
+	case <-ctx.Done():
+		return userProfile{}, errors.Wrap(ctx.Err(), "queueing lookup")
+	}
+
+	select {
+	case err := <-req.result:
+		if err != nil {
+			return userProfile{}, err
+		}
+
+	case <-ctx.Done():
+		return userProfile{}, errors.Wrap(ctx.Err(), "waiting for lookup")
+	}
+
+	if p, ok := u.cached(key); ok {
+		return p, nil
+	}
+
+	return userProfile{}, errors.New("user not found")
+}
+
+// Run collects lookups into batches and executes them and evicts
+// expired profiles from the cache, it never returns
+func (u *userProfileService) Run() {
+	evict := time.NewTicker(userProfileEvictInterval)
+	defer evict.Stop()
+
+	for {
+		var batch []userProfileRequest
+
+		select {
+		case req := <-u.requests:
+			batch = append(batch, req)
+		case <-evict.C:
+			u.evictExpired()
+			continue
+		}
+
+		timeout := time.After(userProfileBatchWait)
+
+	collect:
+		for len(batch) < userProfileBatchSize {
+			select {
+			case req := <-u.requests:
+				batch = append(batch, req)
+			case <-timeout:
+				break collect
+			}
+		}
+
+		err := u.fetch(batch)
+		if err != nil {
+			err = errors.Wrap(err, "fetching user profiles")
+		}
+
+		u.lock.Lock()
+		if err != nil {
+			u.failedUntil = time.Now().Add(userProfileFailureBackoff)
+		} else {
+			u.failedUntil = time.Time{}
+		}
+		u.lock.Unlock()
+
+		for _, req := range batch {
+			req.result <- err
+		}
+	}
+}
+
+func (u *userProfileService) cached(key string) (userProfile, bool) {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	c, ok := u.cache[key]
+	if !ok || time.Now().After(c.expiresAt) {
+		return userProfile{}, false
+	}
+
+	return c.profile, true
+}
+
+// evictExpired removes all profiles from the cache which are no longer
+// valid to keep it from growing with every user ever seen
+func (u *userProfileService) evictExpired() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	now := time.Now()
+	for key, c := range u.cache {
+		if now.After(c.expiresAt) {
+			delete(u.cache, key)
+		}
+	}
+}
+
+func (u *userProfileService) fetch(batch []userProfileRequest) error {
+	params := make(url.Values)
+	for _, req := range batch {
+		switch {
+		case req.ID != "" && !str.StringInSlice(req.ID, params["id"]):
+			params.Add("id", req.ID)
+		case req.Login != "" && !str.StringInSlice(req.Login, params["login"]):
+			params.Add("login", req.Login)
+		}
+	}
+
+	var payload struct {
+		Data []userProfile `json:"data"`
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), userProfileLookupTimeout)
+	defer cancel()
+
+	if err := helix.Do(ctx, helixRequest{
+		Method: http.MethodGet,
+		Path:   "users",
+		Params: params,
+		Auth:   helixAuthApp,
+	}, &payload); err != nil {
+		return errors.Wrap(err, "requesting users")
+	}
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	now := time.Now()
+	for _, p := range payload.Data {
+		entry := cachedUserProfile{profile: p, expiresAt: now.Add(userProfileCacheTTL)}
+		u.cache[userProfileKey(p.ID, "")] = entry
+		u.cache[userProfileKey("", p.Login)] = entry
+	}
+
+	return nil
+}
+
+// isFailing reports whether the last lookup failed recently
+func (u *userProfileService) isFailing() bool {
+	u.lock.RLock()
+	defer u.lock.RUnlock()
+
+	return time.Now().Before(u.failedUntil)
+}
+
+func setUserProfileFields(fields map[string]interface{}, p userProfile) {
+	fields["profile_image_url"] = p.ProfileImageURL
+	fields["broadcaster_type"] = p.BroadcasterType
+	fields["account_created_at"] = p.CreatedAt
+}
+
+func userProfileKey(id, login string) string {
+	if id != "" {
+		return "id:" + id
+	}
+	return "login:" + strings.ToLower(login)
+}
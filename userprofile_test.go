@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Luzifer/twitch-manager/internal/faketwitch"
+)
+
+func addTestProfiles(env *testEnv) []faketwitch.User {
+	users := []faketwitch.User{
+		{ID: "2000", Login: "alice", DisplayName: "Alice", ProfileImageURL: "https://example.com/alice.png"},
+		{ID: "2001", Login: "bob", DisplayName: "Bob", ProfileImageURL: "https://example.com/bob.png"},
+		{ID: "2002", Login: "carol", DisplayName: "Carol", ProfileImageURL: "https://example.com/carol.png"},
+	}
+
+	for _, u := range users {
+		env.fake.AddUser(u)
+	}
+
+	return users
+}
+
+func TestUserProfileBatching(t *testing.T) {
+	env := newTestEnv(t)
+	users := addTestProfiles(env)
+
+	var wg sync.WaitGroup
+	for _, u := range users {
+		wg.Add(1)
+		go func(u faketwitch.User) {
+			defer wg.Done()
+
+			p, err := userProfiles.Get(context.Background(), u.ID, "")
+			if err != nil {
+				t.Errorf("getting profile of %s: %s", u.Login, err)
+				return
+			}
+
+			if p.ProfileImageURL != u.ProfileImageURL {
+				t.Errorf("expected profile image %q, got %q", u.ProfileImageURL, p.ProfileImageURL)
+			}
+		}(u)
+	}
+	wg.Wait()
+
+	reqs := env.fake.HelixRequests("users")
+	if len(reqs) != 1 {
+		t.Fatalf("expected 1 users request, got %d", len(reqs))
+	}
+
+	ids := reqs[0]["id"]
+	sort.Strings(ids)
+	if len(ids) != 3 || ids[0] != "2000" || ids[2] != "2002" {
+		t.Errorf("expected all IDs in one request, got %v", ids)
+	}
+}
+
+func TestUserProfileDedup(t *testing.T) {
+	env := newTestEnv(t)
+	users := addTestProfiles(env)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := userProfiles.Get(context.Background(), users[0].ID, ""); err != nil {
+				t.Errorf("getting profile by ID: %s", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := userProfiles.Get(context.Background(), "", "Bob"); err != nil {
+				t.Errorf("getting profile by login: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	reqs := env.fake.HelixRequests("users")
+	if len(reqs) != 1 {
+		t.Fatalf("expected 1 users request, got %d", len(reqs))
+	}
+	if len(reqs[0]["id"]) != 1 || len(reqs[0]["login"]) != 1 || reqs[0]["login"][0] != "bob" {
+		t.Errorf("expected deduplicated parameters, got %v", reqs[0])
+	}
+
+	// Profiles are cached by ID and login
+	for _, lookup := range [][2]string{{"", users[0].Login}, {users[1].ID, ""}} {
+		if _, err := userProfiles.Get(context.Background(), lookup[0], lookup[1]); err != nil {
+			t.Errorf("getting cached profile: %s", err)
+		}
+	}
+
+	if l := len(env.fake.HelixRequests("users")); l != 1 {
+		t.Errorf("expected cached profiles to be used, got %d requests", l)
+	}
+}
+
+func TestUserProfileTTL(t *testing.T) {
+	env := newTestEnv(t)
+	users := addTestProfiles(env)
+
+	if _, err := userProfiles.Get(context.Background(), users[0].ID, ""); err != nil {
+		t.Fatalf("getting profile: %s", err)
+	}
+
+	// Let the cached profile expire
+	userProfiles.lock.Lock()
+	for key, c := range userProfiles.cache {
+		c.expiresAt = time.Now().Add(-time.Second)
+		userProfiles.cache[key] = c
+	}
+	userProfiles.lock.Unlock()
+
+	if _, ok := userProfiles.cached(userProfileKey(users[0].ID, "")); ok {
+		t.Fatal("expected expired profile not to be returned")
+	}
+
+	userProfiles.evictExpired()
+
+	userProfiles.lock.RLock()
+	if l := len(userProfiles.cache); l != 0 {
+		t.Errorf("expected expired profiles to be evicted, %d left", l)
+	}
+	userProfiles.lock.RUnlock()
+
+	if _, err := userProfiles.Get(context.Background(), users[0].ID, ""); err != nil {
+		t.Fatalf("getting profile: %s", err)
+	}
+
+	if l := len(env.fake.HelixRequests("users")); l != 2 {
+		t.Errorf("expected expired profile to be fetched again, got %d requests", l)
+	}
+}
+
+func TestUserProfileEnrichDoesNotBlock(t *testing.T) {
+	newTestEnv(t)
+
+	// Nobody processes the lookups of this service
+	u := newUserProfileService()
+
+	start := time.Now()
+	fields := map[string]interface{}{}
+	u.Enrich(fields, "2000", "alice")
+
+	if d := time.Since(start); d > 2*userProfileEnrichTimeout {
+		t.Errorf("expected Enrich to give up after %s, took %s", userProfileEnrichTimeout, d)
+	}
+	if _, ok := fields["profile_image_url"]; ok {
+		t.Error("expected no profile fields to be set")
+	}
+
+	// After a failed fetch lookups are skipped without waiting
+	u.failedUntil = time.Now().Add(userProfileFailureBackoff)
+
+	start = time.Now()
+	u.Enrich(fields, "2000", "alice")
+
+	if d := time.Since(start); d > 10*time.Millisecond {
+		t.Errorf("expected Enrich to skip the lookup, took %s", d)
+	}
+}
@@ -68,6 +68,10 @@ func handleWebHookPush(w http.ResponseWriter, r *http.Request) {
 		store.WithModLock(func() error {
 			store.Donations.LastAmount = payload.Amount
 			store.Donations.LastDonator = &payload.Name
+			if store.Session.Live {
+				store.Session.Donations += payload.Amount
+			}
+			addLocalGoalProgress(goalTypeDonations, payload.Amount)
 
 			return nil
 		})